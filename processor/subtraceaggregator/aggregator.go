@@ -1,25 +1,123 @@
 package subtraceaggregator
 
 import (
-	"regexp"
+	"fmt"
+	"math"
+	"sort"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/davidHaunschmied/otel-subtrace-demo/processor/subtraceaggregator/internal/ottl"
 )
 
+// compiledAttrAgg pairs an AttributeAggregation rule with its parsed OTTL
+// condition/source/target so they're compiled once, not on every span.
+type compiledAttrAgg struct {
+	cfg       AttributeAggregation
+	condition ottl.Condition
+	source    *ottl.Path
+	target    *ottl.Path
+	groupBy   []*ottl.Path
+	having    ottl.Condition
+}
+
+// attrPath compiles a GroupBy/Fields entry into an OTTL path. An entry
+// containing "[" is a full OTTL path; any other value is shorthand for the
+// attribute of that name, e.g. "http.method" is `attributes["http.method"]`.
+func attrPath(key string) (*ottl.Path, error) {
+	if strings.Contains(key, "[") {
+		return ottl.ParsePath(key)
+	}
+	return ottl.ParsePath(fmt.Sprintf("attributes[%q]", key))
+}
+
+// compiledEventAgg pairs an EventAggregation rule with its parsed OTTL
+// condition and, for collect, its field paths.
+type compiledEventAgg struct {
+	cfg       EventAggregation
+	condition ottl.Condition
+	fields    []*ottl.Path
+}
+
 // Aggregator applies aggregation rules to a subtrace.
 type Aggregator struct {
-	attrAggs  []AttributeAggregation
-	eventAggs []EventAggregation
+	attrAggs  []compiledAttrAgg
+	eventAggs []compiledEventAgg
 }
 
-// NewAggregator creates a new aggregator with the given rules.
-func NewAggregator(attrAggs []AttributeAggregation, eventAggs []EventAggregation) *Aggregator {
-	return &Aggregator{
-		attrAggs:  attrAggs,
-		eventAggs: eventAggs,
+// NewAggregator compiles the given rules' OTTL conditions and source paths
+// and returns an Aggregator ready to apply them. Compilation happens once,
+// up front, so a malformed expression fails processor startup instead of
+// being silently skipped at flush time.
+func NewAggregator(attrAggs []AttributeAggregation, eventAggs []EventAggregation) (*Aggregator, error) {
+	a := &Aggregator{}
+
+	for _, agg := range attrAggs {
+		compiled := compiledAttrAgg{cfg: agg}
+		if agg.Condition != "" {
+			cond, err := ottl.ParseCondition(agg.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("attribute aggregation %q: %w", agg.Target, err)
+			}
+			compiled.condition = cond
+		}
+		if agg.Source != "" {
+			path, err := ottl.ParsePath(agg.Source)
+			if err != nil {
+				return nil, fmt.Errorf("attribute aggregation %q: %w", agg.Target, err)
+			}
+			compiled.source = path
+		}
+		// A Target containing "[" is an OTTL path (e.g. `attributes["a"]["b"]`),
+		// letting a result land in a nested map; otherwise it's the literal
+		// attribute name to set on the root span, as it always has been.
+		if strings.Contains(agg.Target, "[") {
+			target, err := ottl.ParsePath(agg.Target)
+			if err != nil {
+				return nil, fmt.Errorf("attribute aggregation target %q: %w", agg.Target, err)
+			}
+			compiled.target = target
+		}
+		for _, key := range agg.GroupBy {
+			path, err := attrPath(key)
+			if err != nil {
+				return nil, fmt.Errorf("attribute aggregation %q: group_by %q: %w", agg.Target, key, err)
+			}
+			compiled.groupBy = append(compiled.groupBy, path)
+		}
+		if agg.Having != "" {
+			having, err := ottl.ParseCondition(agg.Having)
+			if err != nil {
+				return nil, fmt.Errorf("attribute aggregation %q: having: %w", agg.Target, err)
+			}
+			compiled.having = having
+		}
+		a.attrAggs = append(a.attrAggs, compiled)
+	}
+
+	for _, agg := range eventAggs {
+		compiled := compiledEventAgg{cfg: agg}
+		if agg.Condition != "" {
+			cond, err := ottl.ParseEventCondition(agg.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("event aggregation %q: %w", agg.Source, err)
+			}
+			compiled.condition = cond
+		}
+		for _, field := range agg.Fields {
+			path, err := attrPath(field)
+			if err != nil {
+				return nil, fmt.Errorf("event aggregation %q: field %q: %w", agg.Source, field, err)
+			}
+			compiled.fields = append(compiled.fields, path)
+		}
+		a.eventAggs = append(a.eventAggs, compiled)
 	}
+
+	return a, nil
 }
 
 // Apply applies all aggregations to the subtrace and enriches the root span.
@@ -28,79 +126,208 @@ func (a *Aggregator) Apply(state *SubtraceState) {
 		return
 	}
 
-	// Apply attribute aggregations
 	for _, agg := range a.attrAggs {
 		a.applyAttributeAggregation(state, agg)
 	}
 
-	// Apply event aggregations
 	for _, agg := range a.eventAggs {
 		a.applyEventAggregation(state, agg)
 	}
 }
 
-func (a *Aggregator) applyAttributeAggregation(state *SubtraceState, agg AttributeAggregation) {
+func (a *Aggregator) spanContext(entry SpanEntry) ottl.Context {
+	return ottl.Context{
+		Resource: entry.Resource.Resource(),
+		Scope:    entry.Scope.Scope(),
+		Span:     entry.Span,
+	}
+}
+
+func (a *Aggregator) applyAttributeAggregation(state *SubtraceState, agg compiledAttrAgg) {
+	if len(agg.groupBy) > 0 {
+		a.applyGroupedAttributeAggregation(state, agg)
+		return
+	}
+
 	var values []pcommon.Value
 	var count int
 
 	for _, span := range state.Spans {
 		// Skip root span for aggregation (we aggregate from children)
-		if isRoot, ok := span.Attributes().Get("subtrace.is_root_span"); ok && isRoot.Bool() {
+		if isRoot, ok := span.Span.Attributes().Get("subtrace.is_root_span"); ok && isRoot.Bool() {
 			continue
 		}
 
-		// Check condition
-		if agg.Condition != "" && !evaluateSpanCondition(span, agg.Condition) {
+		ctx := a.spanContext(span)
+		if agg.condition != nil && !agg.condition.Eval(ctx) {
 			continue
 		}
 
 		count++
 
-		// Get source value if needed
-		if agg.Source != "" {
-			if val := getAttributeValue(span, agg.Source); val.Type() != pcommon.ValueTypeEmpty {
+		if agg.source != nil {
+			if val, ok := agg.source.Get(ctx); ok && val.Type() != pcommon.ValueTypeEmpty {
 				values = append(values, val)
 			}
 		}
 	}
 
-	// No matches - don't set attribute
-	if count == 0 && agg.Aggregation != "count" {
+	if count == 0 && agg.cfg.Aggregation != "count" {
+		return
+	}
+	if len(values) == 0 && agg.cfg.Aggregation != "count" {
+		return
+	}
+
+	result := computeAggregation(agg.cfg.Aggregation, values, count, agg.cfg.MaxValues, agg.cfg.Buckets)
+	if result.Type() == pcommon.ValueTypeEmpty {
+		return
+	}
+	if agg.target != nil {
+		agg.target.Set(a.spanContext(*state.RootSpan), result)
+		return
+	}
+	result.CopyTo(state.RootSpan.Span.Attributes().PutEmpty(agg.cfg.Target))
+}
+
+// groupByKeySeparator joins a GroupBy tuple's per-key values into the map
+// key used in the Target output, e.g. ["GET", "/api/x"] -> "GET /api/x".
+const groupByKeySeparator = " "
+
+// applyGroupedAttributeAggregation is the TraceQL `by(...)`-style variant of
+// applyAttributeAggregation: instead of collapsing every matching span into
+// one scalar, it buckets spans by their GroupBy tuple and aggregates each
+// bucket independently, writing the per-group results to Target as a map.
+func (a *Aggregator) applyGroupedAttributeAggregation(state *SubtraceState, agg compiledAttrAgg) {
+	type bucket struct {
+		values []pcommon.Value
+		count  int
+	}
+	buckets := make(map[string]*bucket)
+	var order []string
+
+	for _, span := range state.Spans {
+		if isRoot, ok := span.Span.Attributes().Get("subtrace.is_root_span"); ok && isRoot.Bool() {
+			continue
+		}
+
+		ctx := a.spanContext(span)
+		if agg.condition != nil && !agg.condition.Eval(ctx) {
+			continue
+		}
+
+		key, ok := groupByKey(ctx, agg.groupBy, agg.cfg.Coalesce)
+		if !ok {
+			continue
+		}
+
+		b, exists := buckets[key]
+		if !exists {
+			b = &bucket{}
+			buckets[key] = b
+			order = append(order, key)
+		}
+		b.count++
+
+		if agg.source != nil {
+			if val, ok := agg.source.Get(ctx); ok && val.Type() != pcommon.ValueTypeEmpty {
+				b.values = append(b.values, val)
+			}
+		}
+	}
+
+	if len(buckets) == 0 {
+		return
+	}
+
+	result := pcommon.NewValueMap()
+	resultMap := result.Map()
+	for _, key := range order {
+		b := buckets[key]
+		if b.count == 0 && agg.cfg.Aggregation != "count" {
+			continue
+		}
+		if len(b.values) == 0 && agg.cfg.Aggregation != "count" {
+			continue
+		}
+
+		groupResult := computeAggregation(agg.cfg.Aggregation, b.values, b.count, agg.cfg.MaxValues, agg.cfg.Buckets)
+		if groupResult.Type() == pcommon.ValueTypeEmpty {
+			continue
+		}
+		if agg.having != nil && !a.evalHaving(agg.having, groupResult) {
+			continue
+		}
+		groupResult.CopyTo(resultMap.PutEmpty(key))
+	}
+
+	if resultMap.Len() == 0 {
 		return
 	}
-	if len(values) == 0 && agg.Aggregation != "count" {
+	if agg.target != nil {
+		agg.target.Set(a.spanContext(*state.RootSpan), result)
 		return
 	}
+	result.CopyTo(state.RootSpan.Span.Attributes().PutEmpty(agg.cfg.Target))
+}
 
-	// Apply aggregation and set on root span
-	result := computeAggregation(agg.Aggregation, values, count, agg.MaxValues)
-	if result.Type() != pcommon.ValueTypeEmpty {
-		result.CopyTo(state.RootSpan.Attributes().PutEmpty(agg.Target))
+// groupByKey resolves a span's GroupBy tuple into the map key used to bucket
+// it. ok is false when a key is missing and coalesce is false, meaning the
+// span should be excluded from every group.
+func groupByKey(ctx ottl.Context, groupBy []*ottl.Path, coalesce bool) (string, bool) {
+	parts := make([]string, len(groupBy))
+	for i, path := range groupBy {
+		val, ok := path.Get(ctx)
+		if !ok {
+			if !coalesce {
+				return "", false
+			}
+			parts[i] = ""
+			continue
+		}
+		parts[i] = val.AsString()
 	}
+	return strings.Join(parts, groupByKeySeparator), true
+}
+
+// evalHaving evaluates Having against a synthetic span exposing the group's
+// aggregated result as attributes["value"], so expressions like
+// `attributes["value"] > 0` can filter out empty or uninteresting groups.
+func (a *Aggregator) evalHaving(having ottl.Condition, groupResult pcommon.Value) bool {
+	span := ptrace.NewSpan()
+	groupResult.CopyTo(span.Attributes().PutEmpty("value"))
+	return having.Eval(ottl.Context{
+		Resource: pcommon.NewResource(),
+		Scope:    pcommon.NewInstrumentationScope(),
+		Span:     span,
+	})
 }
 
-func (a *Aggregator) applyEventAggregation(state *SubtraceState, agg EventAggregation) {
+func (a *Aggregator) applyEventAggregation(state *SubtraceState, agg compiledEventAgg) {
 	var matchingEvents []struct {
 		event      ptrace.SpanEvent
-		sourceSpan ptrace.Span
+		sourceSpan SpanEntry
 	}
 
 	for _, span := range state.Spans {
-		events := span.Events()
+		events := span.Span.Events()
 		for i := 0; i < events.Len(); i++ {
 			event := events.At(i)
-			if event.Name() != agg.Source {
+			if event.Name() != agg.cfg.Source {
 				continue
 			}
 
-			// Check condition on event attributes
-			if agg.Condition != "" && !evaluateEventCondition(event, agg.Condition) {
-				continue
+			if agg.condition != nil {
+				ctx := a.spanContext(span)
+				ctx.Event = &event
+				if !agg.condition.Eval(ctx) {
+					continue
+				}
 			}
 
 			matchingEvents = append(matchingEvents, struct {
 				event      ptrace.SpanEvent
-				sourceSpan ptrace.Span
+				sourceSpan SpanEntry
 			}{event: event, sourceSpan: span})
 		}
 	}
@@ -109,9 +336,9 @@ func (a *Aggregator) applyEventAggregation(state *SubtraceState, agg EventAggreg
 		return
 	}
 
-	switch agg.Aggregation {
+	switch agg.cfg.Aggregation {
 	case "copy_event":
-		maxEvents := agg.MaxEvents
+		maxEvents := agg.cfg.MaxEvents
 		if maxEvents <= 0 {
 			maxEvents = 10
 		}
@@ -119,119 +346,99 @@ func (a *Aggregator) applyEventAggregation(state *SubtraceState, agg EventAggreg
 			if i >= maxEvents {
 				break
 			}
-			// Copy event to root span
-			newEvent := state.RootSpan.Events().AppendEmpty()
+			newEvent := state.RootSpan.Span.Events().AppendEmpty()
 			me.event.CopyTo(newEvent)
-			// Add source_span_id
-			newEvent.Attributes().PutStr("source_span_id", me.sourceSpan.SpanID().String())
+			newEvent.Attributes().PutStr("source_span_id", me.sourceSpan.Span.SpanID().String())
 		}
 
 	case "count":
-		state.RootSpan.Attributes().PutInt(agg.Target, int64(len(matchingEvents)))
+		state.RootSpan.Span.Attributes().PutInt(agg.cfg.Target, int64(len(matchingEvents)))
+
+	case "collect":
+		a.collectEvents(state, agg, matchingEvents)
 	}
 }
 
-// evaluateSpanCondition evaluates a simple OTTL-like condition against a span.
-// Supports: attributes["key"] != nil, attributes["key"] == "value", attributes["key"] == true
-func evaluateSpanCondition(span ptrace.Span, condition string) bool {
-	return evaluateCondition(span.Attributes(), condition)
-}
+// collectEvents implements the "collect" aggregation: it turns each matching
+// event into a record (the requested Fields, plus source_span_id and the
+// event's timestamp) and writes them, in order, as a pcommon.Slice of
+// pcommon.Map under Target -- structured data instead of copied raw events.
+func (a *Aggregator) collectEvents(state *SubtraceState, agg compiledEventAgg, matchingEvents []struct {
+	event      ptrace.SpanEvent
+	sourceSpan SpanEntry
+}) {
+	maxEvents := agg.cfg.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = 10
+	}
 
-// evaluateEventCondition evaluates a condition against an event.
-func evaluateEventCondition(event ptrace.SpanEvent, condition string) bool {
-	return evaluateCondition(event.Attributes(), condition)
-}
+	result := pcommon.NewValueSlice()
+	slice := result.Slice()
+	seen := make(map[string]bool)
 
-// evaluateCondition evaluates a simple condition against attributes.
-func evaluateCondition(attrs pcommon.Map, condition string) bool {
-	// Handle AND conditions
-	if strings.Contains(condition, " and ") {
-		parts := strings.Split(condition, " and ")
-		for _, part := range parts {
-			if !evaluateSingleCondition(attrs, strings.TrimSpace(part)) {
-				return false
-			}
+	for _, me := range matchingEvents {
+		if slice.Len() >= maxEvents {
+			break
 		}
-		return true
-	}
 
-	// Handle OR conditions
-	if strings.Contains(condition, " or ") {
-		parts := strings.Split(condition, " or ")
-		for _, part := range parts {
-			if evaluateSingleCondition(attrs, strings.TrimSpace(part)) {
-				return true
+		ctx := a.spanContext(me.sourceSpan)
+		ctx.Event = &me.event
+
+		if len(agg.cfg.DistinctBy) > 0 {
+			key := distinctKey(ctx, agg.cfg.Fields, agg.fields, agg.cfg.DistinctBy)
+			if seen[key] {
+				continue
 			}
+			seen[key] = true
 		}
-		return false
-	}
-
-	return evaluateSingleCondition(attrs, condition)
-}
-
-func evaluateSingleCondition(attrs pcommon.Map, condition string) bool {
-	// Pattern: attributes["key"] != nil
-	nilCheckPattern := regexp.MustCompile(`attributes\["([^"]+)"\]\s*!=\s*nil`)
-	if matches := nilCheckPattern.FindStringSubmatch(condition); len(matches) == 2 {
-		_, exists := attrs.Get(matches[1])
-		return exists
-	}
-
-	// Pattern: attributes["key"] == nil
-	nilEqPattern := regexp.MustCompile(`attributes\["([^"]+)"\]\s*==\s*nil`)
-	if matches := nilEqPattern.FindStringSubmatch(condition); len(matches) == 2 {
-		_, exists := attrs.Get(matches[1])
-		return !exists
-	}
 
-	// Pattern: attributes["key"] == "value"
-	strEqPattern := regexp.MustCompile(`attributes\["([^"]+)"\]\s*==\s*"([^"]*)"`)
-	if matches := strEqPattern.FindStringSubmatch(condition); len(matches) == 3 {
-		val, exists := attrs.Get(matches[1])
-		return exists && val.Str() == matches[2]
+		record := slice.AppendEmpty().SetEmptyMap()
+		for i, field := range agg.cfg.Fields {
+			if val, ok := agg.fields[i].Get(ctx); ok {
+				val.CopyTo(record.PutEmpty(field))
+			}
+		}
+		record.PutStr("source_span_id", me.sourceSpan.Span.SpanID().String())
+		record.PutStr("event.timestamp", me.event.Timestamp().AsTime().Format(time.RFC3339Nano))
 	}
 
-	// Pattern: attributes["key"] != "value"
-	strNeqPattern := regexp.MustCompile(`attributes\["([^"]+)"\]\s*!=\s*"([^"]*)"`)
-	if matches := strNeqPattern.FindStringSubmatch(condition); len(matches) == 3 {
-		val, exists := attrs.Get(matches[1])
-		return !exists || val.Str() != matches[2]
+	if slice.Len() == 0 {
+		return
 	}
+	result.CopyTo(state.RootSpan.Span.Attributes().PutEmpty(agg.cfg.Target))
+}
 
-	// Pattern: attributes["key"] == true/false
-	boolPattern := regexp.MustCompile(`attributes\["([^"]+)"\]\s*==\s*(true|false)`)
-	if matches := boolPattern.FindStringSubmatch(condition); len(matches) == 3 {
-		val, exists := attrs.Get(matches[1])
-		expected := matches[2] == "true"
-		return exists && val.Bool() == expected
+// distinctKey builds the dedup key for one collect record from the
+// DistinctBy fields' resolved values, in DistinctBy's own order.
+func distinctKey(ctx ottl.Context, allFields []string, allPaths []*ottl.Path, distinctBy []string) string {
+	fieldIndex := make(map[string]int, len(allFields))
+	for i, field := range allFields {
+		fieldIndex[field] = i
 	}
 
-	// Unknown condition - return true (permissive)
-	return true
-}
-
-// getAttributeValue extracts an attribute value from a span using OTTL-like path.
-func getAttributeValue(span ptrace.Span, source string) pcommon.Value {
-	// Pattern: attributes["key"]
-	attrPattern := regexp.MustCompile(`attributes\["([^"]+)"\]`)
-	if matches := attrPattern.FindStringSubmatch(source); len(matches) == 2 {
-		if val, exists := span.Attributes().Get(matches[1]); exists {
-			return val
+	var key strings.Builder
+	for _, field := range distinctBy {
+		if val, ok := allPaths[fieldIndex[field]].Get(ctx); ok {
+			key.WriteString(val.AsString())
 		}
+		key.WriteByte(0)
 	}
-	return pcommon.NewValueEmpty()
+	return key.String()
 }
 
+// defaultHistogramBuckets mirrors the OTel HTTP-latency bucket boundaries,
+// used for aggregation=histogram when AttributeAggregation.Buckets is unset.
+var defaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
 // computeAggregation computes the aggregated value.
-func computeAggregation(aggType string, values []pcommon.Value, count int, maxValues int) pcommon.Value {
+func computeAggregation(aggType string, values []pcommon.Value, count int, maxValues int, buckets []float64) pcommon.Value {
 	if maxValues <= 0 {
 		maxValues = 100
 	}
 
 	switch aggType {
 	case "count":
-		result := pcommon.NewValueInt(int64(count))
-		return result
+		return pcommon.NewValueInt(int64(count))
 
 	case "any":
 		if len(values) > 0 {
@@ -320,11 +527,132 @@ func computeAggregation(aggType string, values []pcommon.Value, count int, maxVa
 			}
 		}
 		return result
+
+	case "p50", "p90", "p95", "p99":
+		sample := numericSample(values, maxValues)
+		if len(sample) == 0 {
+			return pcommon.NewValueEmpty()
+		}
+		q := map[string]float64{"p50": 0.5, "p90": 0.9, "p95": 0.95, "p99": 0.99}[aggType]
+		return pcommon.NewValueDouble(quantile(sample, q))
+
+	case "stddev":
+		sample := numericSample(values, maxValues)
+		if len(sample) == 0 {
+			return pcommon.NewValueEmpty()
+		}
+		return pcommon.NewValueDouble(stddev(sample))
+
+	case "histogram":
+		sample := numericSample(values, maxValues)
+		if len(sample) == 0 {
+			return pcommon.NewValueEmpty()
+		}
+		return buildHistogramValue(sample, buckets)
 	}
 
 	return pcommon.NewValueEmpty()
 }
 
+// numericSample converts values to float64, capped at maxValues as a
+// reservoir: it keeps the first maxValues entries rather than a random
+// subset, so percentile/stddev/histogram accuracy degrades once the cap
+// trims the input. It does not sort the result.
+func numericSample(values []pcommon.Value, maxValues int) []float64 {
+	n := len(values)
+	if n > maxValues {
+		n = maxValues
+	}
+	sample := make([]float64, n)
+	for i := 0; i < n; i++ {
+		sample[i] = getNumericValue(values[i])
+	}
+	return sample
+}
+
+// quantile computes q (0-1) over sample using linear interpolation between
+// the two closest ranks, the same method as numpy's default ("linear").
+// sample is sorted in place.
+func quantile(sample []float64, q float64) float64 {
+	sort.Float64s(sample)
+	if len(sample) == 1 {
+		return sample[0]
+	}
+	pos := q * float64(len(sample)-1)
+	lo := int(pos)
+	hi := lo + 1
+	if hi >= len(sample) {
+		return sample[lo]
+	}
+	frac := pos - float64(lo)
+	return sample[lo] + (sample[hi]-sample[lo])*frac
+}
+
+// stddev computes the population standard deviation of sample.
+func stddev(sample []float64) float64 {
+	var sum float64
+	for _, v := range sample {
+		sum += v
+	}
+	mean := sum / float64(len(sample))
+
+	var sqDiffSum float64
+	for _, v := range sample {
+		d := v - mean
+		sqDiffSum += d * d
+	}
+	return math.Sqrt(sqDiffSum / float64(len(sample)))
+}
+
+// buildHistogramValue renders sample into a pcommon.Map with count, sum,
+// min, max, and a buckets slice of {le, count} cumulative-count pairs over
+// the given (or default) bucket bounds, mirroring buildEdgeMetrics' RED
+// latency histogram but as a plain attribute value instead of a pmetric
+// data point.
+func buildHistogramValue(sample []float64, buckets []float64) pcommon.Value {
+	if len(buckets) == 0 {
+		buckets = defaultHistogramBuckets
+	}
+
+	var sum, min, max float64
+	min = sample[0]
+	max = sample[0]
+	for _, v := range sample {
+		sum += v
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	counts := make([]int64, len(buckets))
+	for _, v := range sample {
+		for i, bound := range buckets {
+			if v <= bound {
+				counts[i]++
+			}
+		}
+	}
+
+	result := pcommon.NewValueMap()
+	m := result.Map()
+	m.PutInt("count", int64(len(sample)))
+	m.PutDouble("sum", sum)
+	m.PutDouble("min", min)
+	m.PutDouble("max", max)
+
+	bucketSlice := m.PutEmptySlice("buckets")
+	for i, bound := range buckets {
+		b := bucketSlice.AppendEmpty().SetEmptyMap()
+		b.PutDouble("le", bound)
+		b.PutInt("count", counts[i])
+	}
+
+	return result
+}
+
 func getNumericValue(v pcommon.Value) float64 {
 	switch v.Type() {
 	case pcommon.ValueTypeInt:
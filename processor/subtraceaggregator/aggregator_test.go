@@ -0,0 +1,482 @@
+package subtraceaggregator
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// newTestSubtrace builds a SubtraceState with a root span and the given
+// number of child spans, each carrying the supplied int attribute value.
+func newTestSubtrace(t *testing.T, values []int64) *SubtraceState {
+	t.Helper()
+
+	root := ptrace.NewSpan()
+	root.SetName("root")
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	state := &SubtraceState{
+		Spans: []SpanEntry{{Span: root, Resource: resource, Scope: scope}},
+	}
+
+	for i, v := range values {
+		child := ptrace.NewSpan()
+		child.SetName("child")
+		child.Attributes().PutInt("value", v)
+		state.Spans = append(state.Spans, SpanEntry{Span: child, Resource: resource, Scope: scope})
+		_ = i
+	}
+
+	state.RootSpan = &state.Spans[0]
+	return state
+}
+
+func mustAggregator(t *testing.T, attrAggs []AttributeAggregation, eventAggs []EventAggregation) *Aggregator {
+	t.Helper()
+	a, err := NewAggregator(attrAggs, eventAggs)
+	if err != nil {
+		t.Fatalf("NewAggregator failed: %v", err)
+	}
+	return a
+}
+
+func TestAggregator_Count(t *testing.T) {
+	state := newTestSubtrace(t, []int64{1, 2, 3})
+	agg := mustAggregator(t, []AttributeAggregation{{Aggregation: "count", Target: "child.count"}}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("child.count")
+	if !ok || v.Int() != 3 {
+		t.Errorf("expected count=3, got %v (ok=%v)", v.AsString(), ok)
+	}
+}
+
+func TestAggregator_SumMinMaxAvg(t *testing.T) {
+	state := newTestSubtrace(t, []int64{1, 2, 3})
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "sum", Source: `attributes["value"]`, Target: "sum"},
+		{Aggregation: "min", Source: `attributes["value"]`, Target: "min"},
+		{Aggregation: "max", Source: `attributes["value"]`, Target: "max"},
+		{Aggregation: "avg", Source: `attributes["value"]`, Target: "avg"},
+	}, nil)
+	agg.Apply(state)
+
+	attrs := state.RootSpan.Span.Attributes()
+	if v, _ := attrs.Get("sum"); v.Int() != 6 {
+		t.Errorf("expected sum=6, got %v", v.AsString())
+	}
+	if v, _ := attrs.Get("min"); v.Double() != 1 {
+		t.Errorf("expected min=1, got %v", v.AsString())
+	}
+	if v, _ := attrs.Get("max"); v.Double() != 3 {
+		t.Errorf("expected max=3, got %v", v.AsString())
+	}
+	if v, _ := attrs.Get("avg"); v.Double() != 2 {
+		t.Errorf("expected avg=2, got %v", v.AsString())
+	}
+}
+
+func TestAggregator_AnyAllAllDistinct(t *testing.T) {
+	state := newTestSubtrace(t, []int64{5, 5, 7})
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "any", Source: `attributes["value"]`, Target: "any"},
+		{Aggregation: "all", Source: `attributes["value"]`, Target: "all"},
+		{Aggregation: "all_distinct", Source: `attributes["value"]`, Target: "all_distinct"},
+	}, nil)
+	agg.Apply(state)
+
+	attrs := state.RootSpan.Span.Attributes()
+	if v, ok := attrs.Get("any"); !ok || v.Int() != 5 {
+		t.Errorf("expected any=5, got %v (ok=%v)", v.AsString(), ok)
+	}
+	if v, _ := attrs.Get("all"); v.Slice().Len() != 3 {
+		t.Errorf("expected all to have 3 elements, got %d", v.Slice().Len())
+	}
+	if v, _ := attrs.Get("all_distinct"); v.Slice().Len() != 2 {
+		t.Errorf("expected all_distinct to have 2 elements, got %d", v.Slice().Len())
+	}
+}
+
+func TestAggregator_ConditionFiltersSpans(t *testing.T) {
+	state := newTestSubtrace(t, []int64{1, 2, 3})
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "count", Condition: `attributes["value"] > 1`, Target: "filtered.count"},
+	}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("filtered.count")
+	if !ok || v.Int() != 2 {
+		t.Errorf("expected filtered count=2, got %v (ok=%v)", v.AsString(), ok)
+	}
+}
+
+func TestAggregator_InvalidConditionFailsAtConstruction(t *testing.T) {
+	_, err := NewAggregator([]AttributeAggregation{
+		{Aggregation: "count", Condition: `attributes["value"] ==`, Target: "x"},
+	}, nil)
+	if err == nil {
+		t.Error("expected NewAggregator to fail on malformed condition")
+	}
+}
+
+func TestAggregator_NestedTarget(t *testing.T) {
+	state := newTestSubtrace(t, []int64{1, 2, 3})
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "sum", Source: `attributes["value"]`, Target: `attributes["stats"]["sum"]`},
+	}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("stats")
+	if !ok || v.Type().String() != "Map" {
+		t.Fatalf("expected a nested 'stats' map, got %v (ok=%v)", v.AsString(), ok)
+	}
+	sum, ok := v.Map().Get("sum")
+	if !ok || sum.Int() != 6 {
+		t.Errorf("expected stats.sum=6, got %v (ok=%v)", sum.AsString(), ok)
+	}
+}
+
+func TestAggregator_SourceFromSpanEvent(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	child := ptrace.NewSpan()
+	event := child.Events().AppendEmpty()
+	event.SetName("exception")
+	event.Attributes().PutStr("exception.message", "boom")
+
+	state := &SubtraceState{
+		Spans: []SpanEntry{
+			{Span: root, Resource: resource, Scope: scope},
+			{Span: child, Resource: resource, Scope: scope},
+		},
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "any", Source: `events["exception"].attributes["exception.message"]`, Target: "last_exception"},
+	}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("last_exception")
+	if !ok || v.Str() != "boom" {
+		t.Errorf("expected last_exception=boom, got %v (ok=%v)", v.AsString(), ok)
+	}
+}
+
+func newGroupedSpan(method, route string) ptrace.Span {
+	span := ptrace.NewSpan()
+	span.SetName("child")
+	span.Attributes().PutStr("http.method", method)
+	span.Attributes().PutStr("http.route", route)
+	return span
+}
+
+func TestAggregator_GroupByProducesPerGroupCounts(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	state := &SubtraceState{Spans: []SpanEntry{{Span: root, Resource: resource, Scope: scope}}}
+	for _, s := range []ptrace.Span{
+		newGroupedSpan("GET", "/api/x"),
+		newGroupedSpan("GET", "/api/x"),
+		newGroupedSpan("POST", "/api/y"),
+	} {
+		state.Spans = append(state.Spans, SpanEntry{Span: s, Resource: resource, Scope: scope})
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "count", GroupBy: []string{"http.method", "http.route"}, Target: "by_route"},
+	}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("by_route")
+	if !ok || v.Type() != pcommon.ValueTypeMap {
+		t.Fatalf("expected a 'by_route' map, got %v (ok=%v)", v.AsString(), ok)
+	}
+	if got, ok := v.Map().Get("GET /api/x"); !ok || got.Int() != 2 {
+		t.Errorf("expected GET /api/x=2, got %v (ok=%v)", got.AsString(), ok)
+	}
+	if got, ok := v.Map().Get("POST /api/y"); !ok || got.Int() != 1 {
+		t.Errorf("expected POST /api/y=1, got %v (ok=%v)", got.AsString(), ok)
+	}
+}
+
+func TestAggregator_GroupByExcludesMissingKeyUnlessCoalesce(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	incomplete := ptrace.NewSpan()
+	incomplete.Attributes().PutStr("http.method", "GET")
+	// No http.route set.
+
+	state := &SubtraceState{
+		Spans: []SpanEntry{
+			{Span: root, Resource: resource, Scope: scope},
+			{Span: incomplete, Resource: resource, Scope: scope},
+		},
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "count", GroupBy: []string{"http.method", "http.route"}, Target: "by_route"},
+	}, nil)
+	agg.Apply(state)
+
+	if _, ok := state.RootSpan.Span.Attributes().Get("by_route"); ok {
+		t.Error("expected no by_route attribute when every span is missing a group-by key")
+	}
+
+	coalescedState := &SubtraceState{
+		Spans: []SpanEntry{
+			{Span: root, Resource: resource, Scope: scope},
+			{Span: incomplete, Resource: resource, Scope: scope},
+		},
+	}
+	coalescedState.RootSpan = &coalescedState.Spans[0]
+
+	coalesceAgg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "count", GroupBy: []string{"http.method", "http.route"}, Coalesce: true, Target: "by_route"},
+	}, nil)
+	coalesceAgg.Apply(coalescedState)
+
+	v, ok := coalescedState.RootSpan.Span.Attributes().Get("by_route")
+	if !ok {
+		t.Fatal("expected by_route to be set when coalesce is enabled")
+	}
+	if got, ok := v.Map().Get("GET "); !ok || got.Int() != 1 {
+		t.Errorf("expected 'GET ' (empty route)=1, got %v (ok=%v)", got.AsString(), ok)
+	}
+}
+
+func TestAggregator_HavingDropsEmptyGroups(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	state := &SubtraceState{Spans: []SpanEntry{{Span: root, Resource: resource, Scope: scope}}}
+	for _, s := range []ptrace.Span{
+		newGroupedSpan("GET", "/api/x"),
+		newGroupedSpan("GET", "/api/x"),
+		newGroupedSpan("POST", "/api/y"),
+	} {
+		state.Spans = append(state.Spans, SpanEntry{Span: s, Resource: resource, Scope: scope})
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, []AttributeAggregation{
+		{
+			Aggregation: "count",
+			GroupBy:     []string{"http.method", "http.route"},
+			Having:      `attributes["value"] > 1`,
+			Target:      "by_route",
+		},
+	}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("by_route")
+	if !ok {
+		t.Fatal("expected by_route to be set")
+	}
+	if _, ok := v.Map().Get("GET /api/x"); !ok {
+		t.Error("expected GET /api/x (count=2) to survive having")
+	}
+	if _, ok := v.Map().Get("POST /api/y"); ok {
+		t.Error("expected POST /api/y (count=1) to be dropped by having")
+	}
+}
+
+func TestAggregator_EventCountAndCopy(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	child := ptrace.NewSpan()
+	e1 := child.Events().AppendEmpty()
+	e1.SetName("exception")
+	e1.Attributes().PutStr("exception.type", "RuntimeError")
+	e2 := child.Events().AppendEmpty()
+	e2.SetName("exception")
+	e2.Attributes().PutStr("exception.type", "IOError")
+
+	state := &SubtraceState{
+		Spans: []SpanEntry{
+			{Span: root, Resource: resource, Scope: scope},
+			{Span: child, Resource: resource, Scope: scope},
+		},
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, nil, []EventAggregation{
+		{Aggregation: "count", Source: "exception", Target: "exception.count"},
+		{Aggregation: "copy_event", Source: "exception", MaxEvents: 1},
+	})
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("exception.count")
+	if !ok || v.Int() != 2 {
+		t.Errorf("expected exception.count=2, got %v (ok=%v)", v.AsString(), ok)
+	}
+	if n := state.RootSpan.Span.Events().Len(); n != 1 {
+		t.Errorf("expected copy_event to respect MaxEvents=1, got %d events", n)
+	}
+}
+
+func newExceptionSpan(excType, message string) ptrace.Span {
+	span := ptrace.NewSpan()
+	event := span.Events().AppendEmpty()
+	event.SetName("exception")
+	event.Attributes().PutStr("exception.type", excType)
+	event.Attributes().PutStr("exception.message", message)
+	return span
+}
+
+func TestAggregator_CollectEventsProducesStructuredSlice(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	state := &SubtraceState{Spans: []SpanEntry{{Span: root, Resource: resource, Scope: scope}}}
+	for _, s := range []ptrace.Span{
+		newExceptionSpan("RuntimeError", "boom"),
+		newExceptionSpan("IOError", "disk full"),
+	} {
+		state.Spans = append(state.Spans, SpanEntry{Span: s, Resource: resource, Scope: scope})
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, nil, []EventAggregation{
+		{
+			Aggregation: "collect",
+			Source:      "exception",
+			Fields:      []string{"exception.type", "exception.message"},
+			Target:      "subtrace.exceptions",
+		},
+	})
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("subtrace.exceptions")
+	if !ok || v.Type() != pcommon.ValueTypeSlice {
+		t.Fatalf("expected a 'subtrace.exceptions' slice, got %v (ok=%v)", v.AsString(), ok)
+	}
+	if n := v.Slice().Len(); n != 2 {
+		t.Fatalf("expected 2 collected records, got %d", n)
+	}
+	record := v.Slice().At(0).Map()
+	if got, ok := record.Get("exception.type"); !ok || got.Str() != "RuntimeError" {
+		t.Errorf("expected exception.type=RuntimeError, got %v (ok=%v)", got.AsString(), ok)
+	}
+	if _, ok := record.Get("source_span_id"); !ok {
+		t.Error("expected source_span_id on every collected record")
+	}
+	if _, ok := record.Get("event.timestamp"); !ok {
+		t.Error("expected event.timestamp on every collected record")
+	}
+	if n := state.RootSpan.Span.Events().Len(); n != 0 {
+		t.Errorf("expected collect not to touch the root span's own Events(), got %d", n)
+	}
+}
+
+func TestAggregator_CollectEventsDistinctByDedupes(t *testing.T) {
+	root := ptrace.NewSpan()
+	root.Attributes().PutBool("subtrace.is_root_span", true)
+	resource := ptrace.NewResourceSpans()
+	scope := ptrace.NewScopeSpans()
+
+	state := &SubtraceState{Spans: []SpanEntry{{Span: root, Resource: resource, Scope: scope}}}
+	for _, s := range []ptrace.Span{
+		newExceptionSpan("RuntimeError", "boom"),
+		newExceptionSpan("RuntimeError", "boom again"),
+		newExceptionSpan("IOError", "disk full"),
+	} {
+		state.Spans = append(state.Spans, SpanEntry{Span: s, Resource: resource, Scope: scope})
+	}
+	state.RootSpan = &state.Spans[0]
+
+	agg := mustAggregator(t, nil, []EventAggregation{
+		{
+			Aggregation: "collect",
+			Source:      "exception",
+			Fields:      []string{"exception.type", "exception.message"},
+			DistinctBy:  []string{"exception.type"},
+			Target:      "subtrace.exceptions",
+		},
+	})
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("subtrace.exceptions")
+	if !ok {
+		t.Fatal("expected subtrace.exceptions to be set")
+	}
+	if n := v.Slice().Len(); n != 2 {
+		t.Errorf("expected distinct_by exception.type to dedupe to 2 records, got %d", n)
+	}
+}
+
+func TestAggregator_PercentileAndStddev(t *testing.T) {
+	state := newTestSubtrace(t, []int64{10, 20, 30, 40, 100})
+	agg := mustAggregator(t, []AttributeAggregation{
+		{Aggregation: "p50", Source: `attributes["value"]`, Target: "p50"},
+		{Aggregation: "p99", Source: `attributes["value"]`, Target: "p99"},
+		{Aggregation: "stddev", Source: `attributes["value"]`, Target: "stddev"},
+	}, nil)
+	agg.Apply(state)
+
+	attrs := state.RootSpan.Span.Attributes()
+	if v, ok := attrs.Get("p50"); !ok || v.Double() != 30 {
+		t.Errorf("expected p50=30, got %v (ok=%v)", v.AsString(), ok)
+	}
+	if v, ok := attrs.Get("p99"); !ok || v.Double() <= 40 {
+		t.Errorf("expected p99 close to the max, got %v (ok=%v)", v.AsString(), ok)
+	}
+	if v, ok := attrs.Get("stddev"); !ok || v.Double() <= 0 {
+		t.Errorf("expected a positive stddev, got %v (ok=%v)", v.AsString(), ok)
+	}
+}
+
+func TestAggregator_HistogramRespectsMaxValuesAndCustomBuckets(t *testing.T) {
+	state := newTestSubtrace(t, []int64{1, 5, 9, 50})
+	agg := mustAggregator(t, []AttributeAggregation{
+		{
+			Aggregation: "histogram",
+			Source:      `attributes["value"]`,
+			Target:      "hist",
+			MaxValues:   3,
+			Buckets:     []float64{10, 100},
+		},
+	}, nil)
+	agg.Apply(state)
+
+	v, ok := state.RootSpan.Span.Attributes().Get("hist")
+	if !ok {
+		t.Fatal("expected hist to be set")
+	}
+	m := v.Map()
+	if count, _ := m.Get("count"); count.Int() != 3 {
+		t.Errorf("expected count=3 (capped by max_values), got %v", count.AsString())
+	}
+	buckets, _ := m.Get("buckets")
+	if n := buckets.Slice().Len(); n != 2 {
+		t.Fatalf("expected 2 buckets, got %d", n)
+	}
+	if le, _ := buckets.Slice().At(0).Map().Get("le"); le.Double() != 10 {
+		t.Errorf("expected first bucket le=10, got %v", le.AsString())
+	}
+	if c, _ := buckets.Slice().At(0).Map().Get("count"); c.Int() != 3 {
+		t.Errorf("expected the 3 capped samples (1, 5, 9; 50 is dropped by the cap) to fall <= 10, got %v", c.AsString())
+	}
+}
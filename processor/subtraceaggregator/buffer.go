@@ -1,6 +1,7 @@
 package subtraceaggregator
 
 import (
+	"container/list"
 	"sync"
 	"time"
 
@@ -10,44 +11,87 @@ import (
 
 // SpanEntry holds a span along with its resource and scope context.
 type SpanEntry struct {
-	Span     ptrace.Span
-	Resource ptrace.ResourceSpans
-	Scope    ptrace.ScopeSpans
+	Span         ptrace.Span
+	Resource     ptrace.ResourceSpans
+	Scope        ptrace.ScopeSpans
+	ResourceHash string
 }
 
-// SubtraceState holds buffered spans for a single subtrace (same trace + resource attributes).
+// SubtraceState holds the spans assigned to a single subtrace (computed at
+// flush time from a TraceState's buffered spans; see assignSubtraces).
 type SubtraceState struct {
 	Spans        []SpanEntry
 	RootSpan     *SpanEntry // Will be determined when flushing (topmost span)
-	SubtraceID   string     // Calculated hash(traceID, resourceAttributes)
+	SubtraceID   string     // Calculated hash(traceID, counter)
 	TraceID      pcommon.TraceID
 	ResourceHash string
 	FirstSeen    time.Time
 }
 
-// TraceState holds all subtraces for a single trace, grouped by resource attributes.
+// TraceState holds all spans buffered for a single trace ID, in arrival
+// order, until the trace is flushed and split into subtraces.
 type TraceState struct {
-	Subtraces map[string]*SubtraceState // keyed by resource hash
+	Spans     []SpanEntry
 	FirstSeen time.Time
+
+	resourceHashes map[string]struct{} // distinct resource hashes seen, for MaxSubtraces
+	lruElem        *list.Element
+}
+
+// EvictedTrace pairs a trace ID with the state it was buffering at the
+// moment Buffer evicted it, so the caller can flush it through the normal
+// aggregation path instead of losing it.
+type EvictedTrace struct {
+	TraceID pcommon.TraceID
+	State   *TraceState
+}
+
+// Stats reports the Buffer's current occupancy, for self-monitoring.
+type Stats struct {
+	TracesBuffered int
+	SpansBuffered  int
+	EvictionsTotal int64
 }
 
-// Buffer manages trace states keyed by trace ID.
+// Buffer manages trace states keyed by trace ID, evicting the
+// least-recently-touched trace once a configured capacity is exceeded.
 type Buffer struct {
-	mu       sync.RWMutex
-	traces   map[string]*TraceState // keyed by trace ID hex string
-	maxSpans int
+	mu    sync.RWMutex
+	lru   *list.List // front = most recently touched trace ID string
+	elems map[string]*list.Element
+
+	traces       map[string]*TraceState // keyed by trace ID hex string
+	maxSpans     int                    // MaxSpansPerTrace: per-trace flush trigger
+	maxSubtraces int                    // MaxSubtraces: per-trace flush trigger
+
+	maxTraces     int // global cap on buffered trace count
+	maxTotalSpans int // global cap on total buffered spans
+	totalSpans    int
+
+	evictions int64
 }
 
-// NewBuffer creates a new trace buffer.
-func NewBuffer(maxSpansPerSubtrace int) *Buffer {
+// NewBuffer creates a new trace buffer. maxTraces and maxTotalSpans are
+// global bounds across all buffered traces; 0 means unlimited.
+func NewBuffer(maxSpansPerTrace, maxSubtraces, maxTraces, maxTotalSpans int) *Buffer {
 	return &Buffer{
-		traces:   make(map[string]*TraceState),
-		maxSpans: maxSpansPerSubtrace,
+		lru:           list.New(),
+		elems:         make(map[string]*list.Element),
+		traces:        make(map[string]*TraceState),
+		maxSpans:      maxSpansPerTrace,
+		maxSubtraces:  maxSubtraces,
+		maxTraces:     maxTraces,
+		maxTotalSpans: maxTotalSpans,
 	}
 }
 
-// Add adds a span to the buffer. Returns the subtrace ID if it should be flushed (max spans reached).
-func (b *Buffer) Add(traceID pcommon.TraceID, resourceHash string, subtraceID string, span ptrace.Span, resource ptrace.ResourceSpans, scope ptrace.ScopeSpans) (flushSubtraceID string, shouldFlush bool) {
+// Add buffers a span under its trace ID. shouldFlush is true if the trace
+// itself just hit MaxSpansPerTrace or MaxSubtraces. evicted lists other
+// traces that had to be evicted (oldest-touched first) to stay within
+// MaxTraces/MaxTotalSpans, already removed from the Buffer; callers must
+// flush these through the normal aggregation path rather than discarding
+// them.
+func (b *Buffer) Add(traceID pcommon.TraceID, resourceHash string, span ptrace.Span, resource ptrace.ResourceSpans, scope ptrace.ScopeSpans) (shouldFlush bool, evicted []EvictedTrace) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -56,39 +100,77 @@ func (b *Buffer) Add(traceID pcommon.TraceID, resourceHash string, subtraceID st
 	traceState, exists := b.traces[traceIDStr]
 	if !exists {
 		traceState = &TraceState{
-			Subtraces: make(map[string]*SubtraceState),
-			FirstSeen: time.Now(),
+			Spans:          make([]SpanEntry, 0),
+			FirstSeen:      time.Now(),
+			resourceHashes: make(map[string]struct{}),
 		}
+		traceState.lruElem = b.lru.PushFront(traceIDStr)
+		b.elems[traceIDStr] = traceState.lruElem
 		b.traces[traceIDStr] = traceState
+	} else {
+		b.lru.MoveToFront(traceState.lruElem)
 	}
 
-	subtraceState, exists := traceState.Subtraces[resourceHash]
-	if !exists {
-		subtraceState = &SubtraceState{
-			Spans:        make([]SpanEntry, 0),
-			SubtraceID:   subtraceID,
-			TraceID:      traceID,
-			ResourceHash: resourceHash,
-			FirstSeen:    time.Now(),
+	traceState.Spans = append(traceState.Spans, SpanEntry{
+		Span:         span,
+		Resource:     resource,
+		Scope:        scope,
+		ResourceHash: resourceHash,
+	})
+	traceState.resourceHashes[resourceHash] = struct{}{}
+	b.totalSpans++
+
+	shouldFlush = len(traceState.Spans) >= b.maxSpans ||
+		(b.maxSubtraces > 0 && len(traceState.resourceHashes) >= b.maxSubtraces)
+
+	evicted = b.evictLocked(traceIDStr)
+	return shouldFlush, evicted
+}
+
+// evictLocked pops the least-recently-touched traces until the buffer is
+// back within MaxTraces/MaxTotalSpans, never evicting keepTraceIDStr (the
+// trace just added to, so a single large trace can't evict itself).
+func (b *Buffer) evictLocked(keepTraceIDStr string) []EvictedTrace {
+	var evicted []EvictedTrace
+
+	overTraces := func() bool { return b.maxTraces > 0 && len(b.traces) > b.maxTraces }
+	overSpans := func() bool { return b.maxTotalSpans > 0 && b.totalSpans > b.maxTotalSpans }
+
+	for overTraces() || overSpans() {
+		elem := b.lru.Back()
+		if elem == nil {
+			break
+		}
+		traceIDStr := elem.Value.(string)
+		if traceIDStr == keepTraceIDStr && b.lru.Len() == 1 {
+			// Only the trace we just added remains; nothing left to evict.
+			break
+		}
+		if traceIDStr == keepTraceIDStr {
+			// Skip past it and evict the next-oldest instead.
+			elem = elem.Prev()
+			if elem == nil {
+				break
+			}
+			traceIDStr = elem.Value.(string)
 		}
-		traceState.Subtraces[resourceHash] = subtraceState
-	}
 
-	// Add span entry
-	subtraceState.Spans = append(subtraceState.Spans, SpanEntry{
-		Span:     span,
-		Resource: resource,
-		Scope:    scope,
-	})
+		traceState := b.traces[traceIDStr]
+		b.lru.Remove(elem)
+		delete(b.elems, traceIDStr)
+		delete(b.traces, traceIDStr)
+		b.totalSpans -= len(traceState.Spans)
+		b.evictions++
 
-	if len(subtraceState.Spans) >= b.maxSpans {
-		return subtraceID, true
+		if len(traceState.Spans) > 0 {
+			evicted = append(evicted, EvictedTrace{TraceID: traceState.Spans[0].Span.TraceID(), State: traceState})
+		}
 	}
-	return "", false
+	return evicted
 }
 
-// RemoveSubtrace removes and returns a specific subtrace from the buffer.
-func (b *Buffer) RemoveSubtrace(traceID pcommon.TraceID, resourceHash string) *SubtraceState {
+// RemoveTrace removes and returns the buffered state for a trace ID.
+func (b *Buffer) RemoveTrace(traceID pcommon.TraceID) *TraceState {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -97,58 +179,54 @@ func (b *Buffer) RemoveSubtrace(traceID pcommon.TraceID, resourceHash string) *S
 	if !exists {
 		return nil
 	}
-
-	subtraceState := traceState.Subtraces[resourceHash]
-	delete(traceState.Subtraces, resourceHash)
-
-	// Clean up trace if no more subtraces
-	if len(traceState.Subtraces) == 0 {
-		delete(b.traces, traceIDStr)
+	delete(b.traces, traceIDStr)
+	if elem, ok := b.elems[traceIDStr]; ok {
+		b.lru.Remove(elem)
+		delete(b.elems, traceIDStr)
 	}
-
-	return subtraceState
+	b.totalSpans -= len(traceState.Spans)
+	return traceState
 }
 
-// GetExpiredTraces returns trace IDs that have exceeded the timeout.
-// Returns a list of (traceID, resourceHash) pairs for all expired subtraces.
-func (b *Buffer) GetExpiredSubtraces(timeout time.Duration) []struct {
-	TraceID      pcommon.TraceID
-	ResourceHash string
-} {
+// GetExpiredTraceIDs returns the trace IDs that have exceeded the timeout.
+func (b *Buffer) GetExpiredTraceIDs(timeout time.Duration) []pcommon.TraceID {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	var expired []struct {
-		TraceID      pcommon.TraceID
-		ResourceHash string
-	}
+	var expired []pcommon.TraceID
 	cutoff := time.Now().Add(-timeout)
 
 	for _, traceState := range b.traces {
-		for resourceHash, subtraceState := range traceState.Subtraces {
-			if subtraceState.FirstSeen.Before(cutoff) {
-				expired = append(expired, struct {
-					TraceID      pcommon.TraceID
-					ResourceHash string
-				}{
-					TraceID:      subtraceState.TraceID,
-					ResourceHash: resourceHash,
-				})
-			}
+		if len(traceState.Spans) == 0 {
+			continue
+		}
+		if traceState.FirstSeen.Before(cutoff) {
+			expired = append(expired, traceState.Spans[0].Span.TraceID())
 		}
 	}
 	return expired
 }
 
-// GetAllSubtraces returns all subtrace states in the buffer.
-func (b *Buffer) GetAllSubtraces() []*SubtraceState {
+// GetAllTraceIDs returns the trace IDs of every buffered trace.
+func (b *Buffer) GetAllTraceIDs() []pcommon.TraceID {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	var subtraces []*SubtraceState
+	var ids []pcommon.TraceID
 	for _, traceState := range b.traces {
-		for _, subtraceState := range traceState.Subtraces {
-			subtraces = append(subtraces, subtraceState)
+		if len(traceState.Spans) > 0 {
+			ids = append(ids, traceState.Spans[0].Span.TraceID())
 		}
 	}
-	return subtraces
+	return ids
+}
+
+// Stats returns the Buffer's current occupancy and lifetime eviction count.
+func (b *Buffer) Stats() Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return Stats{
+		TracesBuffered: len(b.traces),
+		SpansBuffered:  b.totalSpans,
+		EvictionsTotal: b.evictions,
+	}
 }
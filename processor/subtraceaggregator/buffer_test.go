@@ -0,0 +1,99 @@
+package subtraceaggregator
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func traceIDFor(b byte) [16]byte {
+	var id [16]byte
+	id[15] = b
+	return id
+}
+
+func addSpan(t *testing.T, buf *Buffer, traceID byte) (bool, []EvictedTrace) {
+	t.Helper()
+	span := ptrace.NewSpan()
+	span.SetTraceID(traceIDFor(traceID))
+	return buf.Add(traceIDFor(traceID), "res1", span, ptrace.NewResourceSpans(), ptrace.NewScopeSpans())
+}
+
+func TestBuffer_MaxTracesEvictsLeastRecentlyTouched(t *testing.T) {
+	buf := NewBuffer(1000, 0, 2, 0)
+
+	addSpan(t, buf, 1)
+	addSpan(t, buf, 2)
+	// Touch trace 1 again so trace 2 becomes the least-recently-touched.
+	addSpan(t, buf, 1)
+
+	_, evicted := addSpan(t, buf, 3)
+	if len(evicted) != 1 {
+		t.Fatalf("expected 1 eviction, got %d", len(evicted))
+	}
+	if got := evicted[0].TraceID; got != traceIDFor(2) {
+		t.Errorf("expected trace 2 to be evicted, got %v", got)
+	}
+	if evicted[0].State == nil || len(evicted[0].State.Spans) != 1 {
+		t.Errorf("expected evicted state to carry the buffered spans")
+	}
+
+	stats := buf.Stats()
+	if stats.TracesBuffered != 2 {
+		t.Errorf("expected 2 traces buffered after eviction, got %d", stats.TracesBuffered)
+	}
+	if stats.EvictionsTotal != 1 {
+		t.Errorf("expected 1 lifetime eviction, got %d", stats.EvictionsTotal)
+	}
+}
+
+func TestBuffer_MaxTotalSpansEvicts(t *testing.T) {
+	buf := NewBuffer(1000, 0, 0, 2)
+
+	addSpan(t, buf, 1)
+	addSpan(t, buf, 2)
+	_, evicted := addSpan(t, buf, 3)
+
+	if len(evicted) != 1 {
+		t.Fatalf("expected 1 eviction, got %d", len(evicted))
+	}
+	if got := evicted[0].TraceID; got != traceIDFor(1) {
+		t.Errorf("expected trace 1 to be evicted, got %v", got)
+	}
+	if stats := buf.Stats(); stats.SpansBuffered != 2 {
+		t.Errorf("expected 2 spans buffered after eviction, got %d", stats.SpansBuffered)
+	}
+}
+
+func TestBuffer_MaxSubtracesFlagsShouldFlush(t *testing.T) {
+	buf := NewBuffer(1000, 2, 0, 0)
+
+	span1 := ptrace.NewSpan()
+	span1.SetTraceID(traceIDFor(1))
+	shouldFlush, _ := buf.Add(traceIDFor(1), "res1", span1, ptrace.NewResourceSpans(), ptrace.NewScopeSpans())
+	if shouldFlush {
+		t.Fatalf("expected no flush after the first distinct resource")
+	}
+
+	span2 := ptrace.NewSpan()
+	span2.SetTraceID(traceIDFor(1))
+	shouldFlush, _ = buf.Add(traceIDFor(1), "res2", span2, ptrace.NewResourceSpans(), ptrace.NewScopeSpans())
+	if !shouldFlush {
+		t.Errorf("expected flush once MaxSubtraces distinct resources are seen")
+	}
+}
+
+func TestBuffer_RemoveTraceClearsLRUAndTotals(t *testing.T) {
+	buf := NewBuffer(1000, 0, 0, 0)
+	addSpan(t, buf, 1)
+
+	if state := buf.RemoveTrace(traceIDFor(1)); state == nil {
+		t.Fatalf("expected RemoveTrace to return the buffered state")
+	}
+	if state := buf.RemoveTrace(traceIDFor(1)); state != nil {
+		t.Errorf("expected a second RemoveTrace to return nil")
+	}
+	if stats := buf.Stats(); stats.TracesBuffered != 0 || stats.SpansBuffered != 0 {
+		t.Errorf("expected empty buffer after removal, got %+v", stats)
+	}
+}
@@ -2,9 +2,13 @@ package subtraceaggregator
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/collector/component"
+
+	"github.com/davidHaunschmied/otel-subtrace-demo/processor/subtraceaggregator/internal/ottl"
 )
 
 // Config defines the configuration for the subtraceaggregator processor.
@@ -12,8 +16,9 @@ type Config struct {
 	// Timeout is how long to wait for subtrace completion after first span arrives.
 	Timeout time.Duration `mapstructure:"timeout"`
 
-	// MaxSpansPerSubtrace limits memory usage per subtrace.
-	MaxSpansPerSubtrace int `mapstructure:"max_spans_per_subtrace"`
+	// MaxSpansPerTrace limits memory usage by forcing a flush once a trace
+	// has buffered this many spans, even before Timeout elapses.
+	MaxSpansPerTrace int `mapstructure:"max_spans_per_trace"`
 
 	// ErrorMode determines how errors are handled: ignore, silent, propagate.
 	ErrorMode string `mapstructure:"error_mode"`
@@ -23,29 +28,181 @@ type Config struct {
 
 	// EventAggregations defines aggregations on span events.
 	EventAggregations []EventAggregation `mapstructure:"event_aggregations"`
+
+	// MetricsExporter configures emission of RED metrics (call count, error
+	// count, latency) for every subtrace boundary edge. Leave nil to disable.
+	MetricsExporter *MetricsExporterConfig `mapstructure:"metrics_exporter"`
+
+	// TraceLocality selects how spans are distributed across a multi-instance
+	// deployment: "local" (default) buffers every span on the instance that
+	// receives it, while "distributed" shards traces across PeerList by
+	// trace ID so that a trace is always assembled on a single owning peer.
+	TraceLocality string `mapstructure:"trace_locality"`
+
+	// PeerList is the static list of peer addresses (including this
+	// instance's own ListenAddr) used for consistent-hash sharding when
+	// TraceLocality is "distributed".
+	PeerList []string `mapstructure:"peer_list"`
+
+	// ListenAddr is the address this instance listens on for spans forwarded
+	// by peers, and the address other peers use to identify this instance in
+	// PeerList. Required when TraceLocality is "distributed".
+	ListenAddr string `mapstructure:"listen_addr"`
+
+	// MaxSubtraces limits the number of distinct resources (i.e. subtraces)
+	// a single trace may accumulate before it is flushed early, mirroring
+	// MaxSpansPerTrace. 0 means unlimited.
+	MaxSubtraces int `mapstructure:"max_subtraces"`
+
+	// MaxTraces bounds the total number of traces buffered across the whole
+	// processor; once exceeded, the least-recently-touched trace is evicted
+	// and flushed. 0 means unlimited.
+	MaxTraces int `mapstructure:"max_traces"`
+
+	// MaxTotalSpans bounds the total number of spans buffered across every
+	// trace; once exceeded, the least-recently-touched trace is evicted and
+	// flushed. 0 means unlimited.
+	MaxTotalSpans int `mapstructure:"max_total_spans"`
+
+	// SamplingPolicies decides, at flush time, whether each assembled
+	// subtrace is kept or dropped. A subtrace is kept if ANY policy votes to
+	// keep it. Leave empty to keep every subtrace (the default).
+	SamplingPolicies []SamplingPolicy `mapstructure:"sampling_policies"`
+
+	// Storage enables the write-ahead log that lets in-flight traces
+	// survive a collector restart. Leave nil to buffer in memory only (the
+	// default).
+	Storage *StorageConfig `mapstructure:"storage"`
+}
+
+// StorageConfig configures the file-backed write-ahead log.
+type StorageConfig struct {
+	// Directory holds the write-ahead log's segment files.
+	Directory string `mapstructure:"directory"`
+
+	// MaxSegmentBytes rotates to a new segment once the active one reaches
+	// this size. 0 defaults to 64MB.
+	MaxSegmentBytes int64 `mapstructure:"max_segment_bytes"`
+
+	// Fsync controls how often appended records are synced to disk:
+	// "always" (every write, the default), "interval" (once a second), or
+	// "never" (rely on the OS page cache).
+	Fsync string `mapstructure:"fsync"`
+}
+
+// SamplingPolicy defines one tail-sampling decision rule evaluated against a
+// completed SubtraceState.
+type SamplingPolicy struct {
+	// Name labels this policy's votes in the per-policy decision counters.
+	Name string `mapstructure:"name"`
+
+	// Type selects the policy: always_sample, probabilistic, status_code,
+	// latency, numeric_attribute, string_attribute.
+	Type string `mapstructure:"type"`
+
+	// SamplingPercentage is the fraction of subtraces to keep, 0-100.
+	// Required for type=probabilistic.
+	SamplingPercentage float64 `mapstructure:"sampling_percentage"`
+
+	// MinDurationMS is the root span duration threshold, in milliseconds,
+	// at or above which the subtrace is kept. Required for type=latency.
+	MinDurationMS int64 `mapstructure:"min_duration_ms"`
+
+	// Key is the span attribute to inspect. Required for
+	// type=numeric_attribute/string_attribute.
+	Key string `mapstructure:"key"`
+
+	// MinValue and MaxValue bound the attribute value for
+	// type=numeric_attribute.
+	MinValue float64 `mapstructure:"min_value"`
+	MaxValue float64 `mapstructure:"max_value"`
+
+	// Value is the exact string Key must equal for type=string_attribute.
+	Value string `mapstructure:"value"`
+}
+
+// MetricsExporterConfig configures the optional span-metrics emission mode.
+type MetricsExporterConfig struct {
+	// Exporter is the component ID (e.g. "otlp/metrics") of the metrics
+	// exporter that receives the emitted RED metrics. It must be part of a
+	// metrics pipeline in the same collector instance.
+	Exporter string `mapstructure:"exporter"`
+
+	// Dimensions lists additional resource/span attribute keys to project
+	// onto the emitted metric data points, beyond the built-in
+	// caller.service.name/callee.service.name/span.kind/status_code labels.
+	Dimensions []string `mapstructure:"dimensions"`
+
+	// Buckets are the explicit histogram bucket bounds (in seconds) used for
+	// the subtrace call-latency histogram. Defaults to the standard OTel
+	// HTTP-latency buckets when unset.
+	Buckets []float64 `mapstructure:"buckets"`
 }
 
 // AttributeAggregation defines an aggregation rule for span attributes.
 type AttributeAggregation struct {
-	// Aggregation type: count, sum, any, min, max, avg, all, all_distinct
+	// Aggregation type: count, sum, any, min, max, avg, all, all_distinct,
+	// p50, p90, p95, p99, stddev, histogram
 	Aggregation string `mapstructure:"aggregation"`
 
-	// Source is the OTTL path to the source attribute (optional for count).
+	// Source is the OTTL path to the source attribute (optional for count),
+	// e.g. `attributes["k"]`, `resource.attributes["k"]`, `status.code`,
+	// `span.name`, or `events["exception"].attributes["message"]`.
 	Source string `mapstructure:"source"`
 
 	// Condition is an OTTL boolean expression to filter spans.
 	Condition string `mapstructure:"condition"`
 
-	// Target is the attribute name to set on the root span.
+	// Target is the attribute name to set on the root span. An OTTL path
+	// such as `attributes["outer"]["inner"]` targets a nested map instead;
+	// any other value is used verbatim as a flat attribute name.
 	Target string `mapstructure:"target"`
 
-	// MaxValues limits array size for all/all_distinct (default: 100).
+	// MaxValues limits array size for all/all_distinct, and is used as a
+	// reservoir cap on the input sample for p50/p90/p95/p99/stddev/histogram
+	// (default: 100). Percentile accuracy degrades once the cap trims the
+	// collected values, since the reservoir keeps the first MaxValues
+	// samples rather than a random subset.
 	MaxValues int `mapstructure:"max_values"`
+
+	// GroupBy splits the aggregation into one value per distinct tuple of
+	// these attributes' values (TraceQL-style `by(...)`), instead of
+	// collapsing every matching span into a single scalar. Each entry is an
+	// attribute name (or a full OTTL path) evaluated per span; the result is
+	// written to Target as a map from a space-joined tuple of the group's
+	// values to the aggregated value for that group, e.g. grouping by
+	// ["http.method", "http.route"] yields {"GET /api/x": 12}.
+	GroupBy []string `mapstructure:"group_by"`
+
+	// Coalesce includes spans missing a GroupBy key in the grouping,
+	// substituting an empty string for the missing value, instead of
+	// excluding them. Only meaningful when GroupBy is set.
+	Coalesce bool `mapstructure:"coalesce"`
+
+	// Having is an OTTL condition evaluated once per group, against a
+	// synthetic span whose `attributes["value"]` holds that group's
+	// aggregated result; groups for which it evaluates false (e.g. an empty
+	// group) are dropped from Target. Only meaningful when GroupBy is set.
+	Having string `mapstructure:"having"`
+
+	// Buckets are the explicit histogram bucket bounds used for
+	// aggregation=histogram, in the same unit as Source. Defaults to the
+	// standard OTel HTTP-latency buckets when unset.
+	Buckets []float64 `mapstructure:"buckets"`
+
+	// EmitMetric, when Config.MetricsExporter is set, additionally emits
+	// this aggregation's Target value as a subtrace metric data point
+	// (gauge for a scalar result, histogram for aggregation=histogram)
+	// alongside the built-in subtrace duration/child-count/error-count
+	// metrics. Nothing is emitted for a Target given as an OTTL path
+	// (containing "[") or for a non-numeric result, such as the slice of
+	// all/all_distinct or a GroupBy map.
+	EmitMetric bool `mapstructure:"emit_metric"`
 }
 
 // EventAggregation defines an aggregation rule for span events.
 type EventAggregation struct {
-	// Aggregation type: copy_event, count
+	// Aggregation type: copy_event, count, collect
 	Aggregation string `mapstructure:"aggregation"`
 
 	// Source is the event name to match.
@@ -54,11 +211,25 @@ type EventAggregation struct {
 	// Condition is an OTTL boolean expression to filter events.
 	Condition string `mapstructure:"condition"`
 
-	// Target is the attribute name for count aggregation.
+	// Target is the attribute name for count aggregation, or the slice
+	// attribute name for collect aggregation.
 	Target string `mapstructure:"target"`
 
-	// MaxEvents limits copied events for copy_event (default: 10).
+	// MaxEvents limits copied events for copy_event, and collected records
+	// for collect (default: 10).
 	MaxEvents int `mapstructure:"max_events"`
+
+	// Fields is the list of OTTL attribute paths collect reads from each
+	// matching event (e.g. "exception.type", or a full OTTL path such as
+	// `attributes["exception.message"]`) and writes, under that same name,
+	// into every record of the Target slice. Required for collect.
+	Fields []string `mapstructure:"fields"`
+
+	// DistinctBy, for collect, dedupes collected records by a hash of these
+	// fields' values instead of emitting one record per matching event,
+	// analogous to all_distinct in attribute_aggregations. Entries must also
+	// appear in Fields.
+	DistinctBy []string `mapstructure:"distinct_by"`
 }
 
 var _ component.Config = (*Config)(nil)
@@ -68,8 +239,8 @@ func (cfg *Config) Validate() error {
 	if cfg.Timeout <= 0 {
 		return errors.New("timeout must be positive")
 	}
-	if cfg.MaxSpansPerSubtrace <= 0 {
-		return errors.New("max_spans_per_subtrace must be positive")
+	if cfg.MaxSpansPerTrace <= 0 {
+		return errors.New("max_spans_per_trace must be positive")
 	}
 	if cfg.ErrorMode != "" && cfg.ErrorMode != "ignore" && cfg.ErrorMode != "silent" && cfg.ErrorMode != "propagate" {
 		return errors.New("error_mode must be one of: ignore, silent, propagate")
@@ -87,6 +258,51 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if cfg.MetricsExporter != nil && cfg.MetricsExporter.Exporter == "" {
+		return errors.New("metrics_exporter.exporter is required when metrics_exporter is set")
+	}
+
+	if cfg.MaxSubtraces < 0 {
+		return errors.New("max_subtraces must not be negative")
+	}
+	if cfg.MaxTraces < 0 {
+		return errors.New("max_traces must not be negative")
+	}
+	if cfg.MaxTotalSpans < 0 {
+		return errors.New("max_total_spans must not be negative")
+	}
+
+	if _, err := NewSampler(cfg.SamplingPolicies); err != nil {
+		return err
+	}
+
+	if cfg.Storage != nil {
+		if cfg.Storage.Directory == "" {
+			return errors.New("storage.directory is required when storage is set")
+		}
+		if cfg.Storage.MaxSegmentBytes < 0 {
+			return errors.New("storage.max_segment_bytes must not be negative")
+		}
+		switch cfg.Storage.Fsync {
+		case "", "always", "interval", "never":
+		default:
+			return errors.New("storage.fsync must be one of: always, interval, never")
+		}
+	}
+
+	switch cfg.TraceLocality {
+	case "", "local":
+	case "distributed":
+		if cfg.ListenAddr == "" {
+			return errors.New("listen_addr is required when trace_locality is distributed")
+		}
+		if len(cfg.PeerList) == 0 {
+			return errors.New("peer_list is required when trace_locality is distributed")
+		}
+	default:
+		return errors.New("trace_locality must be one of: local, distributed")
+	}
+
 	return nil
 }
 
@@ -94,6 +310,8 @@ func validateAttributeAggregation(agg AttributeAggregation, index int) error {
 	validTypes := map[string]bool{
 		"count": true, "sum": true, "any": true, "min": true,
 		"max": true, "avg": true, "all": true, "all_distinct": true,
+		"p50": true, "p90": true, "p95": true, "p99": true,
+		"stddev": true, "histogram": true,
 	}
 	if !validTypes[agg.Aggregation] {
 		return errors.New("invalid aggregation type in attribute_aggregations")
@@ -104,12 +322,40 @@ func validateAttributeAggregation(agg AttributeAggregation, index int) error {
 	if agg.Aggregation != "count" && agg.Source == "" {
 		return errors.New("source is required for non-count aggregations")
 	}
+	if agg.Source != "" {
+		if _, err := ottl.ParsePath(agg.Source); err != nil {
+			return fmt.Errorf("attribute_aggregations[%d]: invalid source: %w", index, err)
+		}
+	}
+	if strings.Contains(agg.Target, "[") {
+		if _, err := ottl.ParsePath(agg.Target); err != nil {
+			return fmt.Errorf("attribute_aggregations[%d]: invalid target: %w", index, err)
+		}
+	}
+	if agg.Condition != "" {
+		if _, err := ottl.ParseCondition(agg.Condition); err != nil {
+			return fmt.Errorf("attribute_aggregations[%d]: invalid condition: %w", index, err)
+		}
+	}
+	for _, key := range agg.GroupBy {
+		if _, err := attrPath(key); err != nil {
+			return fmt.Errorf("attribute_aggregations[%d]: invalid group_by entry %q: %w", index, key, err)
+		}
+	}
+	if agg.Having != "" {
+		if len(agg.GroupBy) == 0 {
+			return fmt.Errorf("attribute_aggregations[%d]: having requires group_by", index)
+		}
+		if _, err := ottl.ParseCondition(agg.Having); err != nil {
+			return fmt.Errorf("attribute_aggregations[%d]: invalid having: %w", index, err)
+		}
+	}
 	return nil
 }
 
 func validateEventAggregation(agg EventAggregation, index int) error {
-	if agg.Aggregation != "copy_event" && agg.Aggregation != "count" {
-		return errors.New("event aggregation must be copy_event or count")
+	if agg.Aggregation != "copy_event" && agg.Aggregation != "count" && agg.Aggregation != "collect" {
+		return errors.New("event aggregation must be copy_event, count, or collect")
 	}
 	if agg.Source == "" {
 		return errors.New("source (event name) is required in event_aggregations")
@@ -117,5 +363,30 @@ func validateEventAggregation(agg EventAggregation, index int) error {
 	if agg.Aggregation == "count" && agg.Target == "" {
 		return errors.New("target is required for count event aggregation")
 	}
+	if agg.Condition != "" {
+		if _, err := ottl.ParseEventCondition(agg.Condition); err != nil {
+			return fmt.Errorf("event_aggregations[%d]: invalid condition: %w", index, err)
+		}
+	}
+	if agg.Aggregation == "collect" {
+		if agg.Target == "" {
+			return errors.New("target is required for collect event aggregation")
+		}
+		if len(agg.Fields) == 0 {
+			return errors.New("fields is required for collect event aggregation")
+		}
+		fields := make(map[string]bool, len(agg.Fields))
+		for _, field := range agg.Fields {
+			if _, err := attrPath(field); err != nil {
+				return fmt.Errorf("event_aggregations[%d]: invalid field %q: %w", index, field, err)
+			}
+			fields[field] = true
+		}
+		for _, field := range agg.DistinctBy {
+			if !fields[field] {
+				return fmt.Errorf("event_aggregations[%d]: distinct_by %q must also appear in fields", index, field)
+			}
+		}
+	}
 	return nil
 }
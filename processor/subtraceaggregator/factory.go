@@ -25,9 +25,9 @@ func NewFactory() processor.Factory {
 
 func createDefaultConfig() component.Config {
 	return &Config{
-		Timeout:             30 * time.Second,
-		MaxSpansPerSubtrace: 1000,
-		ErrorMode:           "ignore",
+		Timeout:          30 * time.Second,
+		MaxSpansPerTrace: 1000,
+		ErrorMode:        "ignore",
 	}
 }
 
@@ -38,5 +38,5 @@ func createTracesProcessor(
 	nextConsumer consumer.Traces,
 ) (processor.Traces, error) {
 	processorCfg := cfg.(*Config)
-	return newProcessor(set.Logger, processorCfg, nextConsumer)
+	return newProcessor(set.TelemetrySettings, processorCfg, nextConsumer)
 }
@@ -0,0 +1,129 @@
+package subtraceaggregator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// peerForwarder ships spans that belong to a trace owned by another peer to
+// that peer's receiver endpoint, for the "distributed" trace_locality mode.
+type peerForwarder interface {
+	Forward(ctx context.Context, peer string, td ptrace.Traces) error
+}
+
+// forwardPath is the HTTP path the receiver endpoint listens on.
+const forwardPath = "/v1/subtraceaggregator/forward"
+
+// httpPeerForwarder forwards spans to peers over plain HTTP, OTLP-JSON
+// encoded. It intentionally avoids pulling in a gRPC/OTLP receiver+exporter
+// dependency pair for what is, on the wire, just "send this ptrace.Traces to
+// that host".
+type httpPeerForwarder struct {
+	client *http.Client
+}
+
+func newHTTPPeerForwarder() *httpPeerForwarder {
+	return &httpPeerForwarder{client: &http.Client{}}
+}
+
+func (f *httpPeerForwarder) Forward(ctx context.Context, peer string, td ptrace.Traces) error {
+	body, err := (&ptrace.JSONMarshaler{}).MarshalTraces(td)
+	if err != nil {
+		return fmt.Errorf("marshal forwarded spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+peer+forwardPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build forward request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("forward to peer %s: %w", peer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s rejected forwarded spans: %s", peer, resp.Status)
+	}
+	return nil
+}
+
+// bufferSpans adds every span in td to the local buffer and flushes any
+// trace that just hit max_spans_per_trace. It is shared by ConsumeTraces
+// (locally received spans) and handleForwardedSpans (spans forwarded by a
+// peer that owns the trace).
+func (p *subtraceProcessor) bufferSpans(ctx context.Context, td ptrace.Traces) {
+	var toFlush []pcommon.TraceID
+	var evictedTraces []EvictedTrace
+
+	resourceSpans := td.ResourceSpans()
+	for i := 0; i < resourceSpans.Len(); i++ {
+		rs := resourceSpans.At(i)
+		resource := rs.Resource()
+		resourceHash := p.hashResourceAttributes(resource.Attributes())
+
+		scopeSpans := rs.ScopeSpans()
+		for j := 0; j < scopeSpans.Len(); j++ {
+			ss := scopeSpans.At(j)
+			spans := ss.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				traceID := span.TraceID()
+
+				if p.storage != nil {
+					// Write-then-append: persist durably before the span is
+					// considered buffered, so a crash between buffering and
+					// the eventual flush can't lose it.
+					if err := p.storage.Append(traceID, resourceHash, span, rs, ss); err != nil {
+						p.logger.Error("failed to persist span to storage",
+							zap.String("trace_id", traceID.String()), zap.Error(err))
+					}
+				}
+
+				shouldFlush, evicted := p.buffer.Add(traceID, resourceHash, span, rs, ss)
+				if shouldFlush {
+					toFlush = append(toFlush, traceID)
+				}
+				evictedTraces = append(evictedTraces, evicted...)
+			}
+		}
+	}
+
+	for _, traceID := range toFlush {
+		if err := p.flushTrace(ctx, traceID); err != nil {
+			p.logger.Error("failed to flush trace", zap.String("trace_id", traceID.String()), zap.Error(err))
+		}
+	}
+	for _, ev := range evictedTraces {
+		if err := p.flushTraceState(ctx, ev.TraceID, ev.State); err != nil {
+			p.logger.Error("failed to flush evicted trace", zap.String("trace_id", ev.TraceID.String()), zap.Error(err))
+		}
+	}
+}
+
+// handleForwardedSpans is the receiver endpoint for spans forwarded by a
+// peer that determined this instance owns the trace. Forwarded spans are
+// fed into the same Buffer.Add path as locally-received spans.
+func (p *subtraceProcessor) handleForwardedSpans(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	td, err := (&ptrace.JSONUnmarshaler{}).UnmarshalTraces(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.bufferSpans(r.Context(), td)
+	w.WriteHeader(http.StatusOK)
+}
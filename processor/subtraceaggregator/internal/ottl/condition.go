@@ -0,0 +1,94 @@
+package ottl
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+
+	contribottl "github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspan"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/contexts/ottlspanevent"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/pkg/ottl/ottlfuncs"
+)
+
+// Condition is a compiled boolean expression that can be evaluated repeatedly
+// against different Contexts. It's backed by the real contrib pkg/ottl
+// engine (see the package doc comment); ParseCondition and
+// ParseEventCondition just pick which OTTL context -- a span, or a span
+// event -- the expression is parsed and evaluated against.
+type Condition interface {
+	Eval(ctx Context) bool
+}
+
+// telemetrySettings is shared across every parser this package builds; the
+// OTTL parser only uses it for logging, and conditions here are compiled
+// once at startup with no need for a real logger.
+var telemetrySettings = component.TelemetrySettings{Logger: zap.NewNop()}
+
+type spanCondition struct {
+	cond *contribottl.Condition[ottlspan.TransformContext]
+}
+
+func (c *spanCondition) Eval(ctx Context) bool {
+	tCtx := ottlspan.NewTransformContext(ctx.Span, ctx.Scope, ctx.Resource)
+	matched, err := c.cond.Eval(context.Background(), tCtx)
+	if err != nil {
+		// A condition that errors at evaluation time (e.g. a converter
+		// applied to a value of the wrong type) is treated as a non-match,
+		// same as a path that fails to resolve: the rule is skipped for
+		// this span rather than aborting the whole flush.
+		return false
+	}
+	return matched
+}
+
+// ParseCondition compiles an OTTL boolean expression for evaluation against
+// a span plus its resource and instrumentation scope -- AttributeAggregation's
+// Condition and Having. It is called once at processor startup so malformed
+// expressions fail fast instead of silently evaluating to true at runtime.
+func ParseCondition(expr string) (Condition, error) {
+	parser, err := ottlspan.NewParser(ottlfuncs.StandardConverters[ottlspan.TransformContext](), telemetrySettings)
+	if err != nil {
+		return nil, fmt.Errorf("building OTTL span parser: %w", err)
+	}
+	cond, err := parser.ParseCondition(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing condition %q: %w", expr, err)
+	}
+	return &spanCondition{cond: cond}, nil
+}
+
+type spanEventCondition struct {
+	cond *contribottl.Condition[ottlspanevent.TransformContext]
+}
+
+func (c *spanEventCondition) Eval(ctx Context) bool {
+	event := ptrace.NewSpanEvent()
+	if ctx.Event != nil {
+		event = *ctx.Event
+	}
+	tCtx := ottlspanevent.NewTransformContext(event, ctx.Span, ctx.Scope, ctx.Resource)
+	matched, err := c.cond.Eval(context.Background(), tCtx)
+	if err != nil {
+		return false
+	}
+	return matched
+}
+
+// ParseEventCondition compiles an OTTL boolean expression for evaluation
+// against a span event plus its parent span, resource, and instrumentation
+// scope -- EventAggregation's Condition.
+func ParseEventCondition(expr string) (Condition, error) {
+	parser, err := ottlspanevent.NewParser(ottlfuncs.StandardConverters[ottlspanevent.TransformContext](), telemetrySettings)
+	if err != nil {
+		return nil, fmt.Errorf("building OTTL span-event parser: %w", err)
+	}
+	cond, err := parser.ParseCondition(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing condition %q: %w", expr, err)
+	}
+	return &spanEventCondition{cond: cond}, nil
+}
@@ -0,0 +1,174 @@
+// Package ottl evaluates the subtraceaggregator's condition, source, and
+// target fields against a span (plus its resource, scope, and optional event
+// context).
+//
+// Conditions (Condition and Having) are compiled and evaluated by the real
+// contrib pkg/ottl engine -- contexts/ottlspan for span-level conditions,
+// contexts/ottlspanevent for EventAggregation's event-level ones -- so they
+// get the full OTTL boolean grammar and converter function library (IsMatch,
+// Concat, Len, ...), not a repo-local subset. See condition.go.
+//
+// Source/Target/GroupBy/Fields, by contrast, stay on the path-expression
+// mini-parser below: pkg/ottl only exposes path resolution as a side effect
+// of parsing a full editor statement (e.g. `set(a, b)`), not as a reusable
+// Getter/Setter for an externally-chosen destination, and the pinned
+// contrib/ottl version (bounded by this module's go 1.21 floor) has no
+// public ParseValueExpression to get one directly either. This path grammar
+// is not pkg/ottl's: it accepts forms a condition cannot reference, such as
+// `span.name`, `scope.attributes[...]` and `events["name"].attributes[...]`,
+// and it has no converter functions.
+package ottl
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// Context carries everything a condition or path expression can reference
+// when evaluated against a single span.
+type Context struct {
+	Resource pcommon.Resource
+	Scope    pcommon.InstrumentationScope
+	Span     ptrace.Span
+	// Event is set when evaluating an expression against a span event
+	// (e.g. an EventAggregation condition); nil otherwise.
+	Event *ptrace.SpanEvent
+}
+
+// tokenKind identifies the lexical class of a token.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokBool
+	tokNil
+	tokLBracket
+	tokRBracket
+	tokDot
+	tokEq
+	tokNeq
+	tokGt
+	tokGte
+	tokLt
+	tokLte
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex tokenizes an OTTL-subset expression.
+func lex(expr string) ([]token, error) {
+	var toks []token
+	runes := []rune(expr)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '[':
+			toks = append(toks, token{tokLBracket, "["})
+			i++
+		case c == ']':
+			toks = append(toks, token{tokRBracket, "]"})
+			i++
+		case c == '(':
+			toks = append(toks, token{tokLParen, "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")"})
+			i++
+		case c == '.':
+			toks = append(toks, token{tokDot, "."})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string literal in %q", expr)
+			}
+			toks = append(toks, token{tokString, string(runes[i+1 : j])})
+			i = j + 1
+		case c == '=' && i+1 < len(runes) && runes[i+1] == '=':
+			toks = append(toks, token{tokEq, "=="})
+			i += 2
+		case c == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			toks = append(toks, token{tokNeq, "!="})
+			i += 2
+		case c == '>' && i+1 < len(runes) && runes[i+1] == '=':
+			toks = append(toks, token{tokGte, ">="})
+			i += 2
+		case c == '<' && i+1 < len(runes) && runes[i+1] == '=':
+			toks = append(toks, token{tokLte, "<="})
+			i += 2
+		case c == '>':
+			toks = append(toks, token{tokGt, ">"})
+			i++
+		case c == '<':
+			toks = append(toks, token{tokLt, "<"})
+			i++
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(runes) && isIdentPart(runes[j]) {
+				j++
+			}
+			word := string(runes[i:j])
+			toks = append(toks, identOrKeyword(word))
+			i = j
+		case isDigit(c) || (c == '-' && i+1 < len(runes) && isDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (isDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, string(runes[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q in expression %q", c, expr)
+		}
+	}
+	toks = append(toks, token{tokEOF, ""})
+	return toks, nil
+}
+
+func identOrKeyword(word string) token {
+	switch word {
+	case "and":
+		return token{tokAnd, word}
+	case "or":
+		return token{tokOr, word}
+	case "not":
+		return token{tokNot, word}
+	case "true", "false":
+		return token{tokBool, word}
+	case "nil", "null":
+		return token{tokNil, word}
+	default:
+		return token{tokIdent, word}
+	}
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c rune) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,180 @@
+package ottl
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func newSpanCtx(attrs map[string]string) Context {
+	span := ptrace.NewSpan()
+	for k, v := range attrs {
+		span.Attributes().PutStr(k, v)
+	}
+	return Context{
+		Resource: pcommon.NewResource(),
+		Scope:    pcommon.NewInstrumentationScope(),
+		Span:     span,
+	}
+}
+
+func TestParseCondition_StringEquality(t *testing.T) {
+	cond, err := ParseCondition(`attributes["http.method"] == "GET"`)
+	if err != nil {
+		t.Fatalf("ParseCondition failed: %v", err)
+	}
+	if !cond.Eval(newSpanCtx(map[string]string{"http.method": "GET"})) {
+		t.Error("expected condition to match GET")
+	}
+	if cond.Eval(newSpanCtx(map[string]string{"http.method": "POST"})) {
+		t.Error("expected condition not to match POST")
+	}
+}
+
+func TestParseCondition_ExistsAndAndOr(t *testing.T) {
+	cond, err := ParseCondition(`attributes["a"] != nil and (attributes["b"] == "x" or attributes["c"] == "y")`)
+	if err != nil {
+		t.Fatalf("ParseCondition failed: %v", err)
+	}
+	if !cond.Eval(newSpanCtx(map[string]string{"a": "1", "c": "y"})) {
+		t.Error("expected condition to match")
+	}
+	if cond.Eval(newSpanCtx(map[string]string{"c": "y"})) {
+		t.Error("expected condition to fail when 'a' is missing")
+	}
+}
+
+func TestParseCondition_Numeric(t *testing.T) {
+	cond, err := ParseCondition(`attributes["http.status_code"] >= 500`)
+	if err != nil {
+		t.Fatalf("ParseCondition failed: %v", err)
+	}
+	span := ptrace.NewSpan()
+	span.Attributes().PutInt("http.status_code", 503)
+	ctx := Context{Resource: pcommon.NewResource(), Scope: pcommon.NewInstrumentationScope(), Span: span}
+	if !cond.Eval(ctx) {
+		t.Error("expected 503 >= 500 to match")
+	}
+}
+
+func TestParseCondition_InvalidExpression(t *testing.T) {
+	if _, err := ParseCondition(`attributes["a"] ==`); err == nil {
+		t.Error("expected error for malformed condition")
+	}
+}
+
+// TestParseCondition_ConverterFunction exercises IsMatch, a real OTTL
+// converter function with no equivalent in the repo's old hand-rolled
+// condition grammar -- evidence this runs against the actual contrib
+// pkg/ottl engine rather than a lookalike parser.
+func TestParseCondition_ConverterFunction(t *testing.T) {
+	cond, err := ParseCondition(`IsMatch(attributes["http.route"], "^/orders/[0-9]+$")`)
+	if err != nil {
+		t.Fatalf("ParseCondition failed: %v", err)
+	}
+	if !cond.Eval(newSpanCtx(map[string]string{"http.route": "/orders/42"})) {
+		t.Error("expected IsMatch to match /orders/42")
+	}
+	if cond.Eval(newSpanCtx(map[string]string{"http.route": "/orders"})) {
+		t.Error("expected IsMatch not to match /orders")
+	}
+}
+
+func TestParseEventCondition_MatchesAgainstEventAttributes(t *testing.T) {
+	cond, err := ParseEventCondition(`attributes["outcome"] == "failure" and name == "payment.attempt"`)
+	if err != nil {
+		t.Fatalf("ParseEventCondition failed: %v", err)
+	}
+
+	span := ptrace.NewSpan()
+	event := span.Events().AppendEmpty()
+	event.SetName("payment.attempt")
+	event.Attributes().PutStr("outcome", "failure")
+	ctx := Context{
+		Resource: pcommon.NewResource(),
+		Scope:    pcommon.NewInstrumentationScope(),
+		Span:     span,
+		Event:    &event,
+	}
+	if !cond.Eval(ctx) {
+		t.Error("expected condition to match a failed payment.attempt event")
+	}
+
+	event.Attributes().PutStr("outcome", "success")
+	if cond.Eval(ctx) {
+		t.Error("expected condition not to match a successful payment.attempt event")
+	}
+}
+
+func TestParsePath_ResourceAndStatus(t *testing.T) {
+	resPath, err := ParsePath(`resource.attributes["service.name"]`)
+	if err != nil {
+		t.Fatalf("ParsePath failed: %v", err)
+	}
+	resource := pcommon.NewResource()
+	resource.Attributes().PutStr("service.name", "checkout")
+	ctx := Context{Resource: resource, Scope: pcommon.NewInstrumentationScope(), Span: ptrace.NewSpan()}
+	v, ok := resPath.Get(ctx)
+	if !ok || v.Str() != "checkout" {
+		t.Errorf("expected 'checkout', got %v (ok=%v)", v.AsString(), ok)
+	}
+
+	eventsPath, err := ParsePath(`events["exception"].attributes["message"]`)
+	if err != nil {
+		t.Fatalf("ParsePath failed: %v", err)
+	}
+	span := ptrace.NewSpan()
+	event := span.Events().AppendEmpty()
+	event.SetName("exception")
+	event.Attributes().PutStr("message", "boom")
+	ctxEvt := Context{Resource: pcommon.NewResource(), Scope: pcommon.NewInstrumentationScope(), Span: span}
+	v3, ok := eventsPath.Get(ctxEvt)
+	if !ok || v3.Str() != "boom" {
+		t.Errorf("expected 'boom', got %v (ok=%v)", v3.AsString(), ok)
+	}
+
+	statusPath, err := ParsePath(`status.code`)
+	if err != nil {
+		t.Fatalf("ParsePath failed: %v", err)
+	}
+	statusSpan := ptrace.NewSpan()
+	statusSpan.Status().SetCode(ptrace.StatusCodeError)
+	ctx2 := Context{Resource: pcommon.NewResource(), Scope: pcommon.NewInstrumentationScope(), Span: statusSpan}
+	v2, ok := statusPath.Get(ctx2)
+	if !ok || v2.Int() != int64(ptrace.StatusCodeError) {
+		t.Errorf("expected status code %d, got %v", ptrace.StatusCodeError, v2.AsString())
+	}
+}
+
+func TestPath_SetNestedMap(t *testing.T) {
+	path, err := ParsePath(`attributes["outer"]["inner"]`)
+	if err != nil {
+		t.Fatalf("ParsePath failed: %v", err)
+	}
+	ctx := newSpanCtx(nil)
+
+	if !path.Set(ctx, pcommon.NewValueStr("v")) {
+		t.Fatalf("expected Set to succeed")
+	}
+
+	v, ok := path.Get(ctx)
+	if !ok || v.Str() != "v" {
+		t.Errorf("expected nested value 'v', got %v (ok=%v)", v.AsString(), ok)
+	}
+
+	outer, ok := ctx.Span.Attributes().Get("outer")
+	if !ok || outer.Type() != pcommon.ValueTypeMap {
+		t.Fatalf("expected 'outer' to be a map, got %v (ok=%v)", outer.AsString(), ok)
+	}
+}
+
+func TestPath_SetRejectsReadOnlyRoots(t *testing.T) {
+	path, err := ParsePath(`status.code`)
+	if err != nil {
+		t.Fatalf("ParsePath failed: %v", err)
+	}
+	if path.Set(newSpanCtx(nil), pcommon.NewValueInt(1)) {
+		t.Error("expected Set to fail for a read-only root")
+	}
+}
@@ -0,0 +1,200 @@
+package ottl
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// segment is one dotted component of a path, optionally indexed by one or
+// more map keys (e.g. `attributes["a"]["b"]` is the segment {name:
+// "attributes", keys: ["a", "b"]}, for a nested map under attribute "a").
+type segment struct {
+	name string
+	keys []string
+}
+
+// Path is a parsed attribute path expression, e.g. `attributes["k"]`,
+// `resource.attributes["k"]`, `status.code`, `span.name`, or
+// `events["exception"].attributes["message"]`.
+type Path struct {
+	raw      string
+	segments []segment
+}
+
+// String returns the original expression the Path was parsed from.
+func (p *Path) String() string { return p.raw }
+
+// ParsePath parses an OTTL-subset path expression.
+func ParsePath(expr string) (*Path, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []segment
+	i := 0
+	for toks[i].kind != tokEOF {
+		if toks[i].kind != tokIdent {
+			return nil, fmt.Errorf("expected identifier in path %q", expr)
+		}
+		seg := segment{name: toks[i].text}
+		i++
+		for toks[i].kind == tokLBracket {
+			i++
+			if toks[i].kind != tokString {
+				return nil, fmt.Errorf("expected string key in path %q", expr)
+			}
+			seg.keys = append(seg.keys, toks[i].text)
+			i++
+			if toks[i].kind != tokRBracket {
+				return nil, fmt.Errorf("unterminated index in path %q", expr)
+			}
+			i++
+		}
+		segs = append(segs, seg)
+		if toks[i].kind == tokDot {
+			i++
+			continue
+		}
+		if toks[i].kind == tokEOF {
+			break
+		}
+		return nil, fmt.Errorf("unexpected token after path segment in %q", expr)
+	}
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("empty path expression")
+	}
+	return &Path{raw: expr, segments: segs}, nil
+}
+
+// attrRoot resolves the map a leading "attributes"/"resource.attributes"/
+// "scope.attributes" segment addresses, and the nested keys to walk into it.
+func (p *Path) attrRoot(ctx Context) (pcommon.Map, []string, bool) {
+	root := p.segments[0]
+
+	switch root.name {
+	case "attributes":
+		if len(root.keys) == 0 {
+			return pcommon.Map{}, nil, false
+		}
+		var attrs pcommon.Map
+		if ctx.Event != nil {
+			attrs = ctx.Event.Attributes()
+		} else {
+			attrs = ctx.Span.Attributes()
+		}
+		return attrs, root.keys, true
+
+	case "resource":
+		if len(p.segments) < 2 || p.segments[1].name != "attributes" || len(p.segments[1].keys) == 0 {
+			return pcommon.Map{}, nil, false
+		}
+		return ctx.Resource.Attributes(), p.segments[1].keys, true
+
+	case "scope":
+		if len(p.segments) < 2 || p.segments[1].name != "attributes" || len(p.segments[1].keys) == 0 {
+			return pcommon.Map{}, nil, false
+		}
+		return ctx.Scope.Attributes(), p.segments[1].keys, true
+
+	case "events":
+		if len(root.keys) != 1 || len(p.segments) < 2 || p.segments[1].name != "attributes" || len(p.segments[1].keys) == 0 {
+			return pcommon.Map{}, nil, false
+		}
+		events := ctx.Span.Events()
+		for i := 0; i < events.Len(); i++ {
+			if event := events.At(i); event.Name() == root.keys[0] {
+				return event.Attributes(), p.segments[1].keys, true
+			}
+		}
+		return pcommon.Map{}, nil, false
+	}
+
+	return pcommon.Map{}, nil, false
+}
+
+// Get resolves the path against ctx. The second return value is false when
+// the path does not resolve to anything (missing attribute, unknown root, ...).
+func (p *Path) Get(ctx Context) (pcommon.Value, bool) {
+	root := p.segments[0]
+
+	switch root.name {
+	case "attributes", "resource", "scope", "events":
+		m, keys, ok := p.attrRoot(ctx)
+		if !ok {
+			return pcommon.NewValueEmpty(), false
+		}
+		return getNested(m, keys)
+
+	case "status":
+		if len(p.segments) < 2 {
+			return pcommon.NewValueEmpty(), false
+		}
+		switch p.segments[1].name {
+		case "code":
+			v := pcommon.NewValueInt(int64(ctx.Span.Status().Code()))
+			return v, true
+		case "message":
+			v := pcommon.NewValueStr(ctx.Span.Status().Message())
+			return v, true
+		}
+		return pcommon.NewValueEmpty(), false
+
+	case "span":
+		if len(p.segments) < 2 {
+			return pcommon.NewValueEmpty(), false
+		}
+		switch p.segments[1].name {
+		case "name":
+			return pcommon.NewValueStr(ctx.Span.Name()), true
+		case "kind":
+			return pcommon.NewValueStr(ctx.Span.Kind().String()), true
+		}
+		return pcommon.NewValueEmpty(), false
+
+	case "name":
+		// Bare `name` refers to the event name when evaluating event context.
+		if ctx.Event != nil {
+			return pcommon.NewValueStr(ctx.Event.Name()), true
+		}
+		return pcommon.NewValueStr(ctx.Span.Name()), true
+	}
+
+	return pcommon.NewValueEmpty(), false
+}
+
+// getNested walks keys[:len-1] through nested maps under m and returns the
+// value at the final key. ok is false if any intermediate key is missing or
+// isn't itself a map.
+func getNested(m pcommon.Map, keys []string) (pcommon.Value, bool) {
+	for _, key := range keys[:len(keys)-1] {
+		v, ok := m.Get(key)
+		if !ok || v.Type() != pcommon.ValueTypeMap {
+			return pcommon.NewValueEmpty(), false
+		}
+		m = v.Map()
+	}
+	return m.Get(keys[len(keys)-1])
+}
+
+// Set writes value at the path's location in ctx, creating intermediate
+// nested maps as needed. It only supports attribute-map destinations
+// (attributes/resource.attributes/scope.attributes); other roots (status,
+// span, name, events) are read-only and Set returns false for them.
+func (p *Path) Set(ctx Context, value pcommon.Value) bool {
+	m, keys, ok := p.attrRoot(ctx)
+	if !ok || p.segments[0].name == "events" {
+		return false
+	}
+	for _, key := range keys[:len(keys)-1] {
+		existing, ok := m.Get(key)
+		if ok && existing.Type() == pcommon.ValueTypeMap {
+			m = existing.Map()
+			continue
+		}
+		m = m.PutEmptyMap(key)
+	}
+	value.CopyTo(m.PutEmpty(keys[len(keys)-1]))
+	return true
+}
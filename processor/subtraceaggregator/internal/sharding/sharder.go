@@ -0,0 +1,63 @@
+// Package sharding maps trace IDs to owning peers in a multi-instance
+// collector deployment, so that every span of a given trace is assembled on
+// the same node (trace locality), following the design used by Refinery's
+// peer-sharding mode.
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// Sharder decides which peer owns a given trace ID.
+type Sharder interface {
+	// Owner returns the address of the peer that owns traceID and whether
+	// that peer is the local node.
+	Owner(traceID pcommon.TraceID) (peer string, isLocal bool)
+}
+
+// ConsistentHashSharder is a Sharder that uses rendezvous (highest random
+// weight) hashing over a static peer list, so that adding or removing a peer
+// only reshuffles ownership for a small fraction of trace IDs.
+type ConsistentHashSharder struct {
+	self  string
+	peers []string
+}
+
+// NewConsistentHashSharder builds a Sharder over peers (which must include
+// self). peers is sorted internally so ownership decisions are independent
+// of input ordering.
+func NewConsistentHashSharder(self string, peers []string) *ConsistentHashSharder {
+	sorted := make([]string, len(peers))
+	copy(sorted, peers)
+	sort.Strings(sorted)
+	return &ConsistentHashSharder{self: self, peers: sorted}
+}
+
+// Owner implements Sharder.
+func (s *ConsistentHashSharder) Owner(traceID pcommon.TraceID) (string, bool) {
+	if len(s.peers) == 0 {
+		return s.self, true
+	}
+
+	var best string
+	var bestWeight uint64
+	for _, peer := range s.peers {
+		w := rendezvousWeight(traceID, peer)
+		if w > bestWeight || best == "" {
+			bestWeight = w
+			best = peer
+		}
+	}
+	return best, best == s.self
+}
+
+// rendezvousWeight computes the HRW weight of (traceID, peer).
+func rendezvousWeight(traceID pcommon.TraceID, peer string) uint64 {
+	h := fnv.New64a()
+	h.Write(traceID[:])
+	h.Write([]byte(peer))
+	return h.Sum64()
+}
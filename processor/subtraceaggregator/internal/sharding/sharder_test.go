@@ -0,0 +1,45 @@
+package sharding
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+func TestConsistentHashSharder_OwnershipIsDeterministic(t *testing.T) {
+	peers := []string{"peer-a:4317", "peer-b:4317", "peer-c:4317"}
+	s := NewConsistentHashSharder("peer-b:4317", peers)
+
+	traceID := pcommon.TraceID([16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	peer1, local1 := s.Owner(traceID)
+	peer2, local2 := s.Owner(traceID)
+
+	if peer1 != peer2 || local1 != local2 {
+		t.Errorf("expected deterministic ownership, got (%s,%v) then (%s,%v)", peer1, local1, peer2, local2)
+	}
+}
+
+func TestConsistentHashSharder_DistributesAcrossPeers(t *testing.T) {
+	peers := []string{"peer-a:4317", "peer-b:4317", "peer-c:4317"}
+	s := NewConsistentHashSharder("peer-a:4317", peers)
+
+	owners := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		traceID := pcommon.TraceID([16]byte{byte(i), byte(i >> 8), 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+		peer, _ := s.Owner(traceID)
+		owners[peer]++
+	}
+
+	if len(owners) != len(peers) {
+		t.Errorf("expected trace IDs to spread across all %d peers, only hit %d", len(peers), len(owners))
+	}
+}
+
+func TestConsistentHashSharder_SinglePeerIsAlwaysLocal(t *testing.T) {
+	s := NewConsistentHashSharder("self:4317", []string{"self:4317"})
+	traceID := pcommon.TraceID([16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	peer, isLocal := s.Owner(traceID)
+	if !isLocal || peer != "self:4317" {
+		t.Errorf("expected sole peer to own every trace locally, got (%s, %v)", peer, isLocal)
+	}
+}
@@ -0,0 +1,312 @@
+package subtraceaggregator
+
+import (
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// defaultLatencyBuckets mirrors the OTel HTTP-latency bucket boundaries
+// (in seconds), used when MetricsExporterConfig.Buckets is unset.
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// boundaryEdge represents one parent->child call crossing a subtrace
+// boundary, i.e. a service-to-service call in the assembled trace.
+type boundaryEdge struct {
+	callerService string
+	calleeService string
+	kind          ptrace.SpanKind
+	statusCode    ptrace.StatusCode
+	startTime     pcommon.Timestamp
+	endTime       pcommon.Timestamp
+	duration      time.Duration
+	dimensions    map[string]string
+}
+
+// collectBoundaryEdges walks a trace's buffered spans and returns one
+// boundaryEdge for every parent->child pair where shouldStartNewSubtrace
+// reports a subtrace boundary (i.e. a cross-service call).
+func (p *subtraceProcessor) collectBoundaryEdges(traceState *TraceState) []boundaryEdge {
+	spans := traceState.Spans
+	if len(spans) == 0 {
+		return nil
+	}
+
+	spanByID := make(map[string]*SpanEntry, len(spans))
+	for i := range spans {
+		spanByID[spans[i].Span.SpanID().String()] = &spans[i]
+	}
+
+	var edges []boundaryEdge
+	for i := range spans {
+		child := &spans[i]
+		parent, ok := spanByID[child.Span.ParentSpanID().String()]
+		if !ok || child.Span.ParentSpanID().IsEmpty() {
+			continue
+		}
+		if !p.shouldStartNewSubtrace(parent, child) {
+			continue
+		}
+
+		edge := boundaryEdge{
+			callerService: serviceName(parent.Resource.Resource().Attributes()),
+			calleeService: serviceName(child.Resource.Resource().Attributes()),
+			kind:          child.Span.Kind(),
+			statusCode:    child.Span.Status().Code(),
+			startTime:     child.Span.StartTimestamp(),
+			endTime:       child.Span.EndTimestamp(),
+			duration:      time.Duration(child.Span.EndTimestamp() - child.Span.StartTimestamp()),
+		}
+		if dims := p.config.MetricsExporter.Dimensions; len(dims) > 0 {
+			edge.dimensions = make(map[string]string, len(dims))
+			for _, d := range dims {
+				if v, ok := child.Span.Attributes().Get(d); ok {
+					edge.dimensions[d] = v.AsString()
+				} else if v, ok := child.Resource.Resource().Attributes().Get(d); ok {
+					edge.dimensions[d] = v.AsString()
+				}
+			}
+		}
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+// buildSubtraceMetrics renders the per-subtrace measurements described by
+// chunk1-5: subtrace duration, child span count, error count, and one gauge
+// (or histogram, for aggregation=histogram) per AttributeAggregation whose
+// EmitMetric flag is set, reading the already-aggregated value back off the
+// root span. Unlike buildEdgeMetrics, which emits one delta data point per
+// boundary call, these are reported once per flushed subtrace: gauges, plus
+// a delta histogram for aggregation=histogram.
+func buildSubtraceMetrics(state *SubtraceState, attrAggs []AttributeAggregation) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	if state.RootSpan != nil {
+		state.RootSpan.Resource.Resource().CopyTo(rm.Resource())
+	}
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("github.com/davidHaunschmied/otel-subtrace-demo/processor/subtraceaggregator")
+
+	now := pcommon.NewTimestampFromTime(time.Now())
+	attrs := pcommon.NewMap()
+	attrs.PutStr("subtrace.id", state.SubtraceID)
+
+	if state.RootSpan != nil {
+		root := state.RootSpan.Span
+		duration := root.EndTimestamp() - root.StartTimestamp()
+
+		durationMetric := sm.Metrics().AppendEmpty()
+		durationMetric.SetName("subtrace.duration")
+		durationMetric.SetDescription("Duration of the subtrace, root span end minus start.")
+		durationMetric.SetUnit("s")
+		dp := durationMetric.SetEmptyGauge().DataPoints().AppendEmpty()
+		attrs.CopyTo(dp.Attributes())
+		dp.SetTimestamp(now)
+		dp.SetDoubleValue(time.Duration(duration).Seconds())
+	}
+
+	var errorCount int64
+	for _, span := range state.Spans {
+		if span.Span.Status().Code() == ptrace.StatusCodeError {
+			errorCount++
+		}
+	}
+
+	childCountMetric := sm.Metrics().AppendEmpty()
+	childCountMetric.SetName("subtrace.child_span_count")
+	childCountMetric.SetDescription("Number of spans in the subtrace, including the root span.")
+	cdp := childCountMetric.SetEmptyGauge().DataPoints().AppendEmpty()
+	attrs.CopyTo(cdp.Attributes())
+	cdp.SetTimestamp(now)
+	cdp.SetIntValue(int64(len(state.Spans)))
+
+	errorCountMetric := sm.Metrics().AppendEmpty()
+	errorCountMetric.SetName("subtrace.error_count")
+	errorCountMetric.SetDescription("Number of spans in the subtrace with an error status.")
+	edp := errorCountMetric.SetEmptyGauge().DataPoints().AppendEmpty()
+	attrs.CopyTo(edp.Attributes())
+	edp.SetTimestamp(now)
+	edp.SetIntValue(errorCount)
+
+	if state.RootSpan == nil {
+		return md
+	}
+
+	startTs := state.RootSpan.Span.StartTimestamp()
+	for _, agg := range attrAggs {
+		if !agg.EmitMetric || strings.Contains(agg.Target, "[") {
+			continue
+		}
+		v, ok := state.RootSpan.Span.Attributes().Get(agg.Target)
+		if !ok {
+			continue
+		}
+		appendAggregationMetric(sm, agg.Target, v, attrs, startTs, now)
+	}
+
+	return md
+}
+
+// appendAggregationMetric renders one AttributeAggregation's resolved
+// result as a metric: a gauge for a scalar value, or a histogram for the
+// pcommon.Map produced by aggregation=histogram (see buildHistogramValue).
+// buildHistogramValue's buckets carry cumulative le-counts with no overflow
+// bucket, so they're rediffed into per-bucket counts here and an explicit
+// +Inf overflow bucket is appended, matching OTLP's len(BucketCounts) ==
+// len(ExplicitBounds)+1 requirement (see buildEdgeMetrics). The histogram
+// is reported as a delta covering [startTs, now): it's a one-shot snapshot
+// of the subtrace's aggregated attribute, not a running total kept across
+// flushes, so AggregationTemporalityUnspecified (the zero value) isn't a
+// safe default to leave it at.
+func appendAggregationMetric(sm pmetric.ScopeMetrics, name string, v pcommon.Value, attrs pcommon.Map, startTs, now pcommon.Timestamp) {
+	metricName := "subtrace." + name
+
+	if v.Type() == pcommon.ValueTypeMap {
+		m := v.Map()
+		countVal, hasCount := m.Get("count")
+		sumVal, hasSum := m.Get("sum")
+		bucketsVal, hasBuckets := m.Get("buckets")
+		if !hasCount || !hasSum || !hasBuckets {
+			return
+		}
+
+		metric := sm.Metrics().AppendEmpty()
+		metric.SetName(metricName)
+		histogram := metric.SetEmptyHistogram()
+		histogram.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		hdp := histogram.DataPoints().AppendEmpty()
+		attrs.CopyTo(hdp.Attributes())
+		hdp.SetStartTimestamp(startTs)
+		hdp.SetTimestamp(now)
+		hdp.SetCount(uint64(countVal.Int()))
+		hdp.SetSum(sumVal.Double())
+
+		bucketSlice := bucketsVal.Slice()
+		bounds := make([]float64, bucketSlice.Len())
+		counts := make([]uint64, bucketSlice.Len()+1)
+		var prevCumulative int64
+		for i := 0; i < bucketSlice.Len(); i++ {
+			b := bucketSlice.At(i).Map()
+			le, _ := b.Get("le")
+			c, _ := b.Get("count")
+			bounds[i] = le.Double()
+			counts[i] = uint64(c.Int() - prevCumulative)
+			prevCumulative = c.Int()
+		}
+		counts[bucketSlice.Len()] = uint64(countVal.Int() - prevCumulative)
+		hdp.ExplicitBounds().FromRaw(bounds)
+		hdp.BucketCounts().FromRaw(counts)
+		return
+	}
+
+	var n float64
+	switch v.Type() {
+	case pcommon.ValueTypeInt:
+		n = float64(v.Int())
+	case pcommon.ValueTypeDouble:
+		n = v.Double()
+	default:
+		return
+	}
+
+	metric := sm.Metrics().AppendEmpty()
+	metric.SetName(metricName)
+	dp := metric.SetEmptyGauge().DataPoints().AppendEmpty()
+	attrs.CopyTo(dp.Attributes())
+	dp.SetTimestamp(now)
+	dp.SetDoubleValue(n)
+}
+
+func serviceName(attrs pcommon.Map) string {
+	if v, ok := attrs.Get("service.name"); ok {
+		return v.AsString()
+	}
+	return "unknown_service"
+}
+
+// buildEdgeMetrics renders RED metrics (call count, error count, latency
+// histogram) for the given boundary edges. Each edge is reported as its own
+// one-shot data point covering exactly the edge's own [start,end) interval,
+// with no running total kept across flushes, so these are delta temporality
+// rather than cumulative: a cumulative series would have to report a total
+// that's monotonically non-decreasing since a fixed start time for the same
+// attribute set, which a lone count=1 point per call can't provide.
+func buildEdgeMetrics(edges []boundaryEdge, cfg *MetricsExporterConfig) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("github.com/davidHaunschmied/otel-subtrace-demo/processor/subtraceaggregator")
+
+	callCount := sm.Metrics().AppendEmpty()
+	callCount.SetName("subtrace.calls.total")
+	callCount.SetDescription("Number of calls observed at subtrace boundaries.")
+	callCount.SetEmptySum().SetIsMonotonic(true)
+	callCount.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+
+	errorCount := sm.Metrics().AppendEmpty()
+	errorCount.SetName("subtrace.calls.errors")
+	errorCount.SetDescription("Number of calls observed at subtrace boundaries with an error status.")
+	errorCount.SetEmptySum().SetIsMonotonic(true)
+	errorCount.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+
+	latency := sm.Metrics().AppendEmpty()
+	latency.SetName("subtrace.calls.duration")
+	latency.SetDescription("Latency of calls observed at subtrace boundaries, in seconds.")
+	latency.SetUnit("s")
+	latency.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+
+	buckets := cfg.Buckets
+	if len(buckets) == 0 {
+		buckets = defaultLatencyBuckets
+	}
+
+	for _, edge := range edges {
+		attrs := pcommon.NewMap()
+		attrs.PutStr("caller.service.name", edge.callerService)
+		attrs.PutStr("callee.service.name", edge.calleeService)
+		attrs.PutStr("span.kind", edge.kind.String())
+		attrs.PutStr("status_code", edge.statusCode.String())
+		for k, v := range edge.dimensions {
+			attrs.PutStr(k, v)
+		}
+
+		dp := callCount.Sum().DataPoints().AppendEmpty()
+		attrs.CopyTo(dp.Attributes())
+		dp.SetStartTimestamp(edge.startTime)
+		dp.SetTimestamp(edge.endTime)
+		dp.SetIntValue(1)
+
+		if edge.statusCode == ptrace.StatusCodeError {
+			edp := errorCount.Sum().DataPoints().AppendEmpty()
+			attrs.CopyTo(edp.Attributes())
+			edp.SetStartTimestamp(edge.startTime)
+			edp.SetTimestamp(edge.endTime)
+			edp.SetIntValue(1)
+		}
+
+		hdp := latency.Histogram().DataPoints().AppendEmpty()
+		attrs.CopyTo(hdp.Attributes())
+		hdp.SetStartTimestamp(edge.startTime)
+		hdp.SetTimestamp(edge.endTime)
+		seconds := edge.duration.Seconds()
+		hdp.SetCount(1)
+		hdp.SetSum(seconds)
+		hdp.ExplicitBounds().FromRaw(buckets)
+		counts := make([]uint64, len(buckets)+1)
+		bucketIdx := len(buckets)
+		for i, bound := range buckets {
+			if seconds <= bound {
+				bucketIdx = i
+				break
+			}
+		}
+		counts[bucketIdx] = 1
+		hdp.BucketCounts().FromRaw(counts)
+	}
+
+	return md
+}
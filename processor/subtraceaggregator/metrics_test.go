@@ -0,0 +1,187 @@
+package subtraceaggregator
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestCollectBoundaryEdges_ServiceToServiceCall(t *testing.T) {
+	p := &subtraceProcessor{config: &Config{MetricsExporter: &MetricsExporterConfig{}}}
+
+	resA := ptrace.NewResourceSpans()
+	resA.Resource().Attributes().PutStr("service.name", "A")
+	resB := ptrace.NewResourceSpans()
+	resB.Resource().Attributes().PutStr("service.name", "B")
+	scope := ptrace.NewScopeSpans()
+
+	server := ptrace.NewSpan()
+	server.SetName("A-server")
+	server.SetKind(ptrace.SpanKindServer)
+	var sid1 pcommon.SpanID
+	copy(sid1[:], []byte("span1"))
+	server.SetSpanID(sid1)
+
+	client := ptrace.NewSpan()
+	client.SetName("A-client")
+	client.SetKind(ptrace.SpanKindClient)
+	var sid2 pcommon.SpanID
+	copy(sid2[:], []byte("span2"))
+	client.SetSpanID(sid2)
+	client.SetParentSpanID(sid1)
+
+	callee := ptrace.NewSpan()
+	callee.SetName("B-server")
+	callee.SetKind(ptrace.SpanKindServer)
+	callee.Status().SetCode(ptrace.StatusCodeError)
+	callee.SetStartTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, 0)))
+	callee.SetEndTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, int64(50*time.Millisecond))))
+	var sid3 pcommon.SpanID
+	copy(sid3[:], []byte("span3"))
+	callee.SetSpanID(sid3)
+	callee.SetParentSpanID(sid2)
+
+	traceState := &TraceState{
+		Spans: []SpanEntry{
+			{Span: server, Resource: resA, Scope: scope, ResourceHash: "A"},
+			{Span: client, Resource: resA, Scope: scope, ResourceHash: "A"},
+			{Span: callee, Resource: resB, Scope: scope, ResourceHash: "B"},
+		},
+	}
+
+	edges := p.collectBoundaryEdges(traceState)
+	if len(edges) != 1 {
+		t.Fatalf("expected 1 boundary edge, got %d", len(edges))
+	}
+	edge := edges[0]
+	if edge.callerService != "A" || edge.calleeService != "B" {
+		t.Errorf("expected A->B edge, got %s->%s", edge.callerService, edge.calleeService)
+	}
+	if edge.statusCode != ptrace.StatusCodeError {
+		t.Errorf("expected error status, got %v", edge.statusCode)
+	}
+
+	md := buildEdgeMetrics(edges, &MetricsExporterConfig{})
+	if md.MetricCount() != 3 {
+		t.Errorf("expected 3 metrics (calls, errors, duration), got %d", md.MetricCount())
+	}
+	if md.DataPointCount() != 3 {
+		t.Errorf("expected 3 data points (1 call + 1 error + 1 histogram), got %d", md.DataPointCount())
+	}
+}
+
+func TestBuildSubtraceMetrics_OneDataPointPerEmittedAggregation(t *testing.T) {
+	resource := ptrace.NewResourceSpans()
+	resource.Resource().Attributes().PutStr("service.name", "checkout")
+	scope := ptrace.NewScopeSpans()
+
+	root := ptrace.NewSpan()
+	root.SetStartTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, 0)))
+	root.SetEndTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, int64(100*time.Millisecond))))
+	root.Attributes().PutInt("child.count", 2)
+
+	child1 := ptrace.NewSpan()
+	child2 := ptrace.NewSpan()
+	child2.Status().SetCode(ptrace.StatusCodeError)
+
+	state := &SubtraceState{
+		SubtraceID: "abc123",
+		Spans: []SpanEntry{
+			{Span: root, Resource: resource, Scope: scope},
+			{Span: child1, Resource: resource, Scope: scope},
+			{Span: child2, Resource: resource, Scope: scope},
+		},
+	}
+	state.RootSpan = &state.Spans[0]
+
+	attrAggs := []AttributeAggregation{
+		{Aggregation: "count", Target: "child.count", EmitMetric: true},
+		{Aggregation: "sum", Target: "not_emitted"},
+	}
+
+	md := buildSubtraceMetrics(state, attrAggs)
+	if md.MetricCount() != 4 {
+		t.Fatalf("expected 4 metrics (duration, child_span_count, error_count, child.count), got %d", md.MetricCount())
+	}
+	if md.DataPointCount() != 4 {
+		t.Errorf("expected exactly one data point per metric, got %d", md.DataPointCount())
+	}
+
+	sm := md.ResourceMetrics().At(0).ScopeMetrics().At(0)
+	if name, _ := md.ResourceMetrics().At(0).Resource().Attributes().Get("service.name"); name.Str() != "checkout" {
+		t.Errorf("expected the subtrace's resource to be mirrored, got %v", name.AsString())
+	}
+
+	var sawChildCountMetric bool
+	for i := 0; i < sm.Metrics().Len(); i++ {
+		m := sm.Metrics().At(i)
+		switch m.Name() {
+		case "subtrace.error_count":
+			if v := m.Gauge().DataPoints().At(0).IntValue(); v != 1 {
+				t.Errorf("expected error_count=1, got %d", v)
+			}
+		case "subtrace.child.count":
+			sawChildCountMetric = true
+			if v := m.Gauge().DataPoints().At(0).DoubleValue(); v != 2 {
+				t.Errorf("expected emitted aggregation value=2, got %v", v)
+			}
+		}
+	}
+	if !sawChildCountMetric {
+		t.Error("expected an emitted metric for the EmitMetric aggregation")
+	}
+}
+
+func TestBuildSubtraceMetrics_HistogramAggregationIsPerBucketWithOverflow(t *testing.T) {
+	resource := ptrace.NewResourceSpans()
+	resource.Resource().Attributes().PutStr("service.name", "checkout")
+	scope := ptrace.NewScopeSpans()
+
+	root := ptrace.NewSpan()
+	root.SetStartTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, 0)))
+	root.SetEndTimestamp(pcommon.NewTimestampFromTime(time.Unix(0, int64(100*time.Millisecond))))
+	histVal := buildHistogramValue([]float64{0.5, 1.5, 3}, []float64{1, 2, 5})
+	histVal.CopyTo(root.Attributes().PutEmpty("latency"))
+
+	state := &SubtraceState{
+		SubtraceID: "abc123",
+		Spans:      []SpanEntry{{Span: root, Resource: resource, Scope: scope}},
+	}
+	state.RootSpan = &state.Spans[0]
+
+	attrAggs := []AttributeAggregation{
+		{Aggregation: "histogram", Target: "latency", EmitMetric: true},
+	}
+
+	md := buildSubtraceMetrics(state, attrAggs)
+
+	var found bool
+	sm := md.ResourceMetrics().At(0).ScopeMetrics().At(0)
+	for i := 0; i < sm.Metrics().Len(); i++ {
+		m := sm.Metrics().At(i)
+		if m.Name() != "subtrace.latency" {
+			continue
+		}
+		found = true
+		dp := m.Histogram().DataPoints().At(0)
+		bounds := dp.ExplicitBounds().AsRaw()
+		counts := dp.BucketCounts().AsRaw()
+		if len(counts) != len(bounds)+1 {
+			t.Fatalf("expected len(BucketCounts) == len(ExplicitBounds)+1, got %d bounds and %d counts", len(bounds), len(counts))
+		}
+		wantCounts := []uint64{1, 1, 1, 0}
+		for i, want := range wantCounts {
+			if counts[i] != want {
+				t.Errorf("bucket %d: expected count %d, got %d", i, want, counts[i])
+			}
+		}
+		if dp.Count() != 3 {
+			t.Errorf("expected total count 3, got %d", dp.Count())
+		}
+	}
+	if !found {
+		t.Fatal("expected a subtrace.latency histogram metric")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"net/http"
 	"sort"
 	"sync"
 	"time"
@@ -13,7 +14,10 @@ import (
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
+
+	"github.com/davidHaunschmied/otel-subtrace-demo/processor/subtraceaggregator/internal/sharding"
 )
 
 type subtraceProcessor struct {
@@ -22,24 +26,115 @@ type subtraceProcessor struct {
 	nextConsumer consumer.Traces
 	buffer       *Buffer
 	aggregator   *Aggregator
+	sampler      *Sampler
+
+	// storage persists buffered spans to a write-ahead log so in-flight
+	// traces survive a collector restart. Nil disables persistence.
+	storage Storage
+
+	// samplingDecisions counts sampling policy votes, by policy name and
+	// keep/drop outcome; set in registerSelfMetrics.
+	samplingDecisions metric.Int64Counter
+
+	// metricsConsumer receives RED metrics emitted at subtrace boundary
+	// edges when config.MetricsExporter is set; resolved from the named
+	// exporter at Start. Nil disables span-metrics emission.
+	metricsConsumer consumer.Metrics
+
+	// sharder and forwarder are set when config.TraceLocality is
+	// "distributed", sharding trace ownership across config.PeerList so a
+	// trace is always assembled on a single owning peer.
+	sharder    sharding.Sharder
+	forwarder  peerForwarder
+	httpServer *http.Server
 
 	shutdownCh chan struct{}
 	wg         sync.WaitGroup
 }
 
-func newProcessor(logger *zap.Logger, cfg *Config, next consumer.Traces) (*subtraceProcessor, error) {
+func newProcessor(telemetry component.TelemetrySettings, cfg *Config, next consumer.Traces) (*subtraceProcessor, error) {
+	aggregator, err := NewAggregator(cfg.AttributeAggregations, cfg.EventAggregations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile aggregation rules: %w", err)
+	}
+
+	sampler, err := NewSampler(cfg.SamplingPolicies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile sampling policies: %w", err)
+	}
+
+	var storage Storage
+	if cfg.Storage != nil {
+		fileStorage, err := NewFileStorage(cfg.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open storage: %w", err)
+		}
+		storage = fileStorage
+	}
+
 	p := &subtraceProcessor{
-		logger:       logger,
+		logger:       telemetry.Logger,
 		config:       cfg,
 		nextConsumer: next,
-		buffer:       NewBuffer(cfg.MaxSpansPerTrace),
-		aggregator:   NewAggregator(cfg.AttributeAggregations, cfg.EventAggregations),
+		buffer:       NewBuffer(cfg.MaxSpansPerTrace, cfg.MaxSubtraces, cfg.MaxTraces, cfg.MaxTotalSpans),
+		aggregator:   aggregator,
+		sampler:      sampler,
+		storage:      storage,
 		shutdownCh:   make(chan struct{}),
 	}
+
+	if err := p.registerSelfMetrics(telemetry); err != nil {
+		return nil, fmt.Errorf("failed to register self-metrics: %w", err)
+	}
+
 	return p, nil
 }
 
 func (p *subtraceProcessor) Start(ctx context.Context, host component.Host) error {
+	if p.storage != nil {
+		replayed, err := p.storage.Replay()
+		if err != nil {
+			return fmt.Errorf("failed to replay storage: %w", err)
+		}
+		for _, rs := range replayed {
+			// Spans recovered here are already durable; re-adding them to
+			// the in-memory Buffer must not re-Append them to storage.
+			_, evicted := p.buffer.Add(rs.TraceID, rs.ResourceHash, rs.Span, rs.Resource, rs.Scope)
+			for _, ev := range evicted {
+				if err := p.flushTraceState(ctx, ev.TraceID, ev.State); err != nil {
+					p.logger.Error("failed to flush evicted trace", zap.String("trace_id", ev.TraceID.String()), zap.Error(err))
+				}
+			}
+		}
+		if len(replayed) > 0 {
+			p.logger.Info("replayed buffered spans from storage", zap.Int("spans", len(replayed)))
+		}
+	}
+
+	if p.config.MetricsExporter != nil {
+		exporter, err := p.resolveMetricsExporter(host)
+		if err != nil {
+			return err
+		}
+		p.metricsConsumer = exporter
+	}
+
+	if p.config.TraceLocality == "distributed" {
+		p.sharder = sharding.NewConsistentHashSharder(p.config.ListenAddr, p.config.PeerList)
+		p.forwarder = newHTTPPeerForwarder()
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(forwardPath, p.handleForwardedSpans)
+		p.httpServer = &http.Server{Addr: p.config.ListenAddr, Handler: mux}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			if err := p.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				p.logger.Error("peer forwarding listener failed", zap.Error(err))
+			}
+		}()
+	}
+
 	p.wg.Add(1)
 	go p.flushLoop()
 	p.logger.Info("subtraceaggregator processor started",
@@ -48,8 +143,34 @@ func (p *subtraceProcessor) Start(ctx context.Context, host component.Host) erro
 	return nil
 }
 
+// resolveMetricsExporter looks up the consumer.Metrics for the exporter
+// named in config.MetricsExporter.Exporter, following the same
+// host.GetExporters() pattern used by spanmetrics/servicegraph processors.
+func (p *subtraceProcessor) resolveMetricsExporter(host component.Host) (consumer.Metrics, error) {
+	var id component.ID
+	if err := id.UnmarshalText([]byte(p.config.MetricsExporter.Exporter)); err != nil {
+		return nil, fmt.Errorf("invalid metrics_exporter.exporter %q: %w", p.config.MetricsExporter.Exporter, err)
+	}
+
+	for _, exporters := range host.GetExporters() {
+		if comp, ok := exporters[id]; ok {
+			metricsExporter, ok := comp.(consumer.Metrics)
+			if !ok {
+				return nil, fmt.Errorf("exporter %q does not support metrics", id)
+			}
+			return metricsExporter, nil
+		}
+	}
+	return nil, fmt.Errorf("metrics exporter %q not found", id)
+}
+
 func (p *subtraceProcessor) Shutdown(ctx context.Context) error {
 	close(p.shutdownCh)
+	if p.httpServer != nil {
+		if err := p.httpServer.Shutdown(ctx); err != nil {
+			p.logger.Error("failed to shut down peer forwarding listener", zap.Error(err))
+		}
+	}
 	p.wg.Wait()
 
 	// Flush all remaining traces
@@ -61,6 +182,12 @@ func (p *subtraceProcessor) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	if p.storage != nil {
+		if err := p.storage.Close(); err != nil {
+			p.logger.Error("failed to close storage", zap.Error(err))
+		}
+	}
+
 	p.logger.Info("subtraceaggregator processor shutdown complete")
 	return nil
 }
@@ -70,41 +197,71 @@ func (p *subtraceProcessor) Capabilities() consumer.Capabilities {
 }
 
 func (p *subtraceProcessor) ConsumeTraces(ctx context.Context, td ptrace.Traces) error {
-	var toFlush []pcommon.TraceID
+	if p.sharder == nil {
+		p.bufferSpans(ctx, td)
+		return nil
+	}
+
+	// Distributed mode: split the incoming batch by owning peer so each
+	// span is buffered on the single node responsible for assembling its
+	// trace (trace locality), forwarding spans owned elsewhere.
+	local := ptrace.NewTraces()
+	forwardByPeer := make(map[string]ptrace.Traces)
 
 	resourceSpans := td.ResourceSpans()
 	for i := 0; i < resourceSpans.Len(); i++ {
 		rs := resourceSpans.At(i)
-		resource := rs.Resource()
-		resourceHash := p.hashResourceAttributes(resource.Attributes())
-
 		scopeSpans := rs.ScopeSpans()
 		for j := 0; j < scopeSpans.Len(); j++ {
 			ss := scopeSpans.At(j)
 			spans := ss.Spans()
 			for k := 0; k < spans.Len(); k++ {
 				span := spans.At(k)
-				traceID := span.TraceID()
-
-				shouldFlush := p.buffer.Add(traceID, resourceHash, span, rs, ss)
-				if shouldFlush {
-					toFlush = append(toFlush, traceID)
+				peer, isLocal := p.sharder.Owner(span.TraceID())
+
+				dest := local
+				if !isLocal {
+					if _, ok := forwardByPeer[peer]; !ok {
+						forwardByPeer[peer] = ptrace.NewTraces()
+					}
+					dest = forwardByPeer[peer]
 				}
+				destRS := appendResourceSpans(dest, rs)
+				destSS := appendScopeSpans(destRS, ss)
+				span.CopyTo(destSS.Spans().AppendEmpty())
 			}
 		}
 	}
 
-	for _, traceID := range toFlush {
-		if err := p.flushTrace(ctx, traceID); err != nil {
-			p.logger.Error("failed to flush trace",
-				zap.String("trace_id", traceID.String()),
-				zap.Error(err))
+	p.bufferSpans(ctx, local)
+
+	for peer, peerTraces := range forwardByPeer {
+		if err := p.forwarder.Forward(ctx, peer, peerTraces); err != nil {
+			// Peer forwarding failed: fall back to buffering locally rather
+			// than dropping the spans, so a flaky peer doesn't lose data.
+			p.logger.Warn("peer forwarding failed, buffering locally instead",
+				zap.String("peer", peer), zap.Error(err))
+			p.bufferSpans(ctx, peerTraces)
 		}
 	}
 
 	return nil
 }
 
+// appendResourceSpans appends a new ResourceSpans to dest with src's resource copied over.
+func appendResourceSpans(dest ptrace.Traces, src ptrace.ResourceSpans) ptrace.ResourceSpans {
+	rs := dest.ResourceSpans().AppendEmpty()
+	src.Resource().CopyTo(rs.Resource())
+	return rs
+}
+
+// appendScopeSpans appends a new ScopeSpans to rs with src's scope copied over.
+func appendScopeSpans(rs ptrace.ResourceSpans, src ptrace.ScopeSpans) ptrace.ScopeSpans {
+	ss := rs.ScopeSpans().AppendEmpty()
+	src.Scope().CopyTo(ss.Scope())
+	return ss
+}
+
 func (p *subtraceProcessor) flushLoop() {
 	defer p.wg.Done()
 
@@ -132,6 +289,35 @@ func (p *subtraceProcessor) flushTrace(ctx context.Context, traceID pcommon.Trac
 	if traceState == nil || len(traceState.Spans) == 0 {
 		return nil
 	}
+	return p.flushTraceState(ctx, traceID, traceState)
+}
+
+// flushTraceState assembles and emits the subtraces for a TraceState already
+// removed from the Buffer, e.g. by flushTrace or by an LRU eviction. Once a
+// trace reaches here it's done with the Buffer for good, so storage can
+// reclaim whatever it persisted for it.
+func (p *subtraceProcessor) flushTraceState(ctx context.Context, traceID pcommon.TraceID, traceState *TraceState) error {
+	if p.storage != nil {
+		defer func() {
+			if err := p.storage.Tombstone(traceID); err != nil {
+				p.logger.Error("failed to tombstone flushed trace in storage",
+					zap.String("trace_id", traceID.String()), zap.Error(err))
+			}
+		}()
+	}
+
+	if len(traceState.Spans) == 0 {
+		return nil
+	}
+
+	if p.metricsConsumer != nil {
+		if edges := p.collectBoundaryEdges(traceState); len(edges) > 0 {
+			md := buildEdgeMetrics(edges, p.config.MetricsExporter)
+			if err := p.metricsConsumer.ConsumeMetrics(ctx, md); err != nil {
+				p.logger.Error("failed to emit subtrace boundary metrics", zap.Error(err))
+			}
+		}
+	}
 
 	// Assign spans to subtraces based on parent-child relationships and service boundaries
 	subtraces := p.assignSubtraces(traceState, traceID)
@@ -152,6 +338,25 @@ func (p *subtraceProcessor) flushTrace(ctx context.Context, traceID pcommon.Trac
 			p.aggregator.Apply(state)
 		}
 
+		if p.metricsConsumer != nil {
+			md := buildSubtraceMetrics(state, p.config.AttributeAggregations)
+			if err := p.metricsConsumer.ConsumeMetrics(ctx, md); err != nil {
+				p.logger.Error("failed to emit subtrace metrics", zap.Error(err))
+			}
+		}
+
+		// Sampling decision applies atomically to every span of the
+		// subtrace: either all of them ship together, or the whole
+		// subtrace is dropped before reaching nextConsumer.
+		keep, votes := p.sampler.Decide(state)
+		for policy, kept := range votes {
+			p.recordSamplingDecision(policy, kept)
+		}
+		p.recordSamplingDecision("_overall", keep)
+		if !keep {
+			continue
+		}
+
 		// Build and send output
 		td := ptrace.NewTraces()
 		if len(state.Spans) > 0 {
@@ -348,4 +553,3 @@ func (p *subtraceProcessor) hashResourceAttributes(attrs pcommon.Map) string {
 	hash := sha256.Sum256([]byte(builder))
 	return hex.EncodeToString(hash[:8]) // Use first 8 bytes (64 bits)
 }
-
@@ -0,0 +1,182 @@
+package subtraceaggregator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// compiledPolicy is a validated SamplingPolicy ready to evaluate.
+type compiledPolicy struct {
+	cfg SamplingPolicy
+}
+
+// Sampler decides, for each completed subtrace, whether it should be kept,
+// by OR-ing the votes of every configured policy: a subtrace is kept if ANY
+// policy votes to keep it. This mirrors the tail_sampling processor's
+// policy-evaluation model, applied here at subtrace rather than trace
+// granularity so that all spans of a kept subtrace ship together.
+type Sampler struct {
+	policies []compiledPolicy
+}
+
+// NewSampler validates and compiles the given policies. An empty/nil list
+// means sampling is disabled: every subtrace is kept.
+func NewSampler(policies []SamplingPolicy) (*Sampler, error) {
+	s := &Sampler{}
+	seenNames := make(map[string]bool, len(policies))
+
+	for i, cfg := range policies {
+		if err := validateSamplingPolicy(cfg, i); err != nil {
+			return nil, err
+		}
+		if seenNames[cfg.Name] {
+			return nil, fmt.Errorf("sampling_policies[%d]: duplicate policy name %q", i, cfg.Name)
+		}
+		seenNames[cfg.Name] = true
+		s.policies = append(s.policies, compiledPolicy{cfg: cfg})
+	}
+
+	return s, nil
+}
+
+// Decide returns the OR'd keep/drop decision for the subtrace, along with
+// each policy's individual vote (keyed by policy name) so the caller can
+// record per-policy decision counters.
+func (s *Sampler) Decide(state *SubtraceState) (keep bool, votes map[string]bool) {
+	if len(s.policies) == 0 {
+		return true, nil
+	}
+
+	votes = make(map[string]bool, len(s.policies))
+	for _, p := range s.policies {
+		kept := p.evaluate(state)
+		votes[p.cfg.Name] = kept
+		keep = keep || kept
+	}
+	return keep, votes
+}
+
+func (p compiledPolicy) evaluate(state *SubtraceState) bool {
+	switch p.cfg.Type {
+	case "always_sample":
+		return true
+	case "probabilistic":
+		return p.evaluateProbabilistic(state)
+	case "status_code":
+		return p.evaluateStatusCode(state)
+	case "latency":
+		return p.evaluateLatency(state)
+	case "numeric_attribute":
+		return p.evaluateNumericAttribute(state)
+	case "string_attribute":
+		return p.evaluateStringAttribute(state)
+	default:
+		return false
+	}
+}
+
+// evaluateProbabilistic hashes the SubtraceID to a stable [0, 100) bucket,
+// so repeated evaluations (e.g. after a retry) agree, and keeps the
+// subtrace if that bucket falls within SamplingPercentage.
+func (p compiledPolicy) evaluateProbabilistic(state *SubtraceState) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(state.SubtraceID))
+	bucket := float64(h.Sum32()%10000) / 100.0 // 0.00-99.99, 2 decimal places of precision
+	return bucket < p.cfg.SamplingPercentage
+}
+
+// evaluateStatusCode keeps the subtrace if at least one span has error
+// status; a subtrace with no errors is dropped by this policy.
+func (p compiledPolicy) evaluateStatusCode(state *SubtraceState) bool {
+	for _, span := range state.Spans {
+		if span.Span.Status().Code() == ptrace.StatusCodeError {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateLatency keeps the subtrace if its root span's duration meets or
+// exceeds MinDurationMS.
+func (p compiledPolicy) evaluateLatency(state *SubtraceState) bool {
+	if state.RootSpan == nil {
+		return false
+	}
+	duration := time.Duration(state.RootSpan.Span.EndTimestamp() - state.RootSpan.Span.StartTimestamp())
+	return duration >= time.Duration(p.cfg.MinDurationMS)*time.Millisecond
+}
+
+// evaluateNumericAttribute keeps the subtrace if any span's Key attribute is
+// numeric and falls within [MinValue, MaxValue].
+func (p compiledPolicy) evaluateNumericAttribute(state *SubtraceState) bool {
+	for _, span := range state.Spans {
+		v, ok := span.Span.Attributes().Get(p.cfg.Key)
+		if !ok {
+			continue
+		}
+		n, ok := numericValue(v)
+		if ok && n >= p.cfg.MinValue && n <= p.cfg.MaxValue {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateStringAttribute keeps the subtrace if any span's Key attribute
+// equals Value.
+func (p compiledPolicy) evaluateStringAttribute(state *SubtraceState) bool {
+	for _, span := range state.Spans {
+		if v, ok := span.Span.Attributes().Get(p.cfg.Key); ok && v.AsString() == p.cfg.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func numericValue(v pcommon.Value) (float64, bool) {
+	switch v.Type() {
+	case pcommon.ValueTypeInt:
+		return float64(v.Int()), true
+	case pcommon.ValueTypeDouble:
+		return v.Double(), true
+	default:
+		return 0, false
+	}
+}
+
+func validateSamplingPolicy(cfg SamplingPolicy, index int) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("sampling_policies[%d]: name is required", index)
+	}
+
+	switch cfg.Type {
+	case "always_sample", "status_code":
+	case "probabilistic":
+		if cfg.SamplingPercentage < 0 || cfg.SamplingPercentage > 100 {
+			return fmt.Errorf("sampling_policies[%d]: sampling_percentage must be between 0 and 100", index)
+		}
+	case "latency":
+		if cfg.MinDurationMS <= 0 {
+			return fmt.Errorf("sampling_policies[%d]: min_duration_ms must be positive", index)
+		}
+	case "numeric_attribute":
+		if cfg.Key == "" {
+			return fmt.Errorf("sampling_policies[%d]: key is required", index)
+		}
+		if cfg.MinValue > cfg.MaxValue {
+			return fmt.Errorf("sampling_policies[%d]: min_value must not exceed max_value", index)
+		}
+	case "string_attribute":
+		if cfg.Key == "" {
+			return fmt.Errorf("sampling_policies[%d]: key is required", index)
+		}
+	default:
+		return fmt.Errorf("sampling_policies[%d]: invalid type %q", index, cfg.Type)
+	}
+
+	return nil
+}
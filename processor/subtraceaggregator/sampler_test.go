@@ -0,0 +1,166 @@
+package subtraceaggregator
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func mustSampler(t *testing.T, policies []SamplingPolicy) *Sampler {
+	t.Helper()
+	s, err := NewSampler(policies)
+	if err != nil {
+		t.Fatalf("NewSampler failed: %v", err)
+	}
+	return s
+}
+
+func newDurationSubtrace(t *testing.T, id string, duration time.Duration, statusCode ptrace.StatusCode, attrs map[string]int64) *SubtraceState {
+	t.Helper()
+
+	root := ptrace.NewSpan()
+	root.SetStartTimestamp(1000)
+	root.SetEndTimestamp(pcommon.Timestamp(1000 + uint64(duration)))
+	root.Status().SetCode(statusCode)
+	for k, v := range attrs {
+		root.Attributes().PutInt(k, v)
+	}
+
+	state := &SubtraceState{
+		SubtraceID: id,
+		Spans:      []SpanEntry{{Span: root, Resource: ptrace.NewResourceSpans(), Scope: ptrace.NewScopeSpans()}},
+	}
+	state.RootSpan = &state.Spans[0]
+	return state
+}
+
+func TestNewSampler_EmptyPoliciesKeepsEverything(t *testing.T) {
+	s := mustSampler(t, nil)
+	state := newDurationSubtrace(t, "s1", time.Millisecond, ptrace.StatusCodeUnset, nil)
+
+	keep, votes := s.Decide(state)
+	if !keep {
+		t.Errorf("expected keep=true with no policies configured")
+	}
+	if votes != nil {
+		t.Errorf("expected nil votes with no policies configured, got %v", votes)
+	}
+}
+
+func TestNewSampler_RejectsInvalidPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SamplingPolicy
+	}{
+		{"missing name", SamplingPolicy{Type: "always_sample"}},
+		{"unknown type", SamplingPolicy{Name: "p", Type: "bogus"}},
+		{"probabilistic out of range", SamplingPolicy{Name: "p", Type: "probabilistic", SamplingPercentage: 150}},
+		{"latency non-positive threshold", SamplingPolicy{Name: "p", Type: "latency", MinDurationMS: 0}},
+		{"numeric_attribute missing key", SamplingPolicy{Name: "p", Type: "numeric_attribute"}},
+		{"numeric_attribute inverted range", SamplingPolicy{Name: "p", Type: "numeric_attribute", Key: "k", MinValue: 10, MaxValue: 1}},
+		{"string_attribute missing key", SamplingPolicy{Name: "p", Type: "string_attribute"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSampler([]SamplingPolicy{tt.policy}); err == nil {
+				t.Errorf("expected an error for %+v", tt.policy)
+			}
+		})
+	}
+}
+
+func TestNewSampler_RejectsDuplicateNames(t *testing.T) {
+	_, err := NewSampler([]SamplingPolicy{
+		{Name: "dup", Type: "always_sample"},
+		{Name: "dup", Type: "always_sample"},
+	})
+	if err == nil {
+		t.Errorf("expected an error for duplicate policy names")
+	}
+}
+
+func TestSampler_StatusCodeKeepsOnlyErrors(t *testing.T) {
+	s := mustSampler(t, []SamplingPolicy{{Name: "errors", Type: "status_code"}})
+
+	okState := newDurationSubtrace(t, "ok", time.Millisecond, ptrace.StatusCodeOk, nil)
+	if keep, _ := s.Decide(okState); keep {
+		t.Errorf("expected status_code policy to drop a subtrace with no error spans")
+	}
+
+	errState := newDurationSubtrace(t, "err", time.Millisecond, ptrace.StatusCodeError, nil)
+	if keep, _ := s.Decide(errState); !keep {
+		t.Errorf("expected status_code policy to keep a subtrace with an error span")
+	}
+}
+
+func TestSampler_LatencyThreshold(t *testing.T) {
+	s := mustSampler(t, []SamplingPolicy{{Name: "slow", Type: "latency", MinDurationMS: 100}})
+
+	fast := newDurationSubtrace(t, "fast", 50*time.Millisecond, ptrace.StatusCodeUnset, nil)
+	if keep, _ := s.Decide(fast); keep {
+		t.Errorf("expected latency policy to drop a subtrace below the threshold")
+	}
+
+	slow := newDurationSubtrace(t, "slow", 150*time.Millisecond, ptrace.StatusCodeUnset, nil)
+	if keep, _ := s.Decide(slow); !keep {
+		t.Errorf("expected latency policy to keep a subtrace at/above the threshold")
+	}
+}
+
+func TestSampler_NumericAttributeRange(t *testing.T) {
+	s := mustSampler(t, []SamplingPolicy{{Name: "big", Type: "numeric_attribute", Key: "retries", MinValue: 3, MaxValue: 10}})
+
+	inRange := newDurationSubtrace(t, "in", time.Millisecond, ptrace.StatusCodeUnset, map[string]int64{"retries": 5})
+	if keep, _ := s.Decide(inRange); !keep {
+		t.Errorf("expected numeric_attribute policy to keep a value inside the range")
+	}
+
+	outOfRange := newDurationSubtrace(t, "out", time.Millisecond, ptrace.StatusCodeUnset, map[string]int64{"retries": 1})
+	if keep, _ := s.Decide(outOfRange); keep {
+		t.Errorf("expected numeric_attribute policy to drop a value outside the range")
+	}
+}
+
+func TestSampler_OrsMultiplePolicies(t *testing.T) {
+	s := mustSampler(t, []SamplingPolicy{
+		{Name: "errors", Type: "status_code"},
+		{Name: "slow", Type: "latency", MinDurationMS: 100},
+	})
+
+	// Neither policy votes to keep: fast and no error.
+	state := newDurationSubtrace(t, "s1", 10*time.Millisecond, ptrace.StatusCodeOk, nil)
+	keep, votes := s.Decide(state)
+	if keep {
+		t.Errorf("expected overall decision to drop when no policy votes keep")
+	}
+	if votes["errors"] || votes["slow"] {
+		t.Errorf("expected both policies to vote drop, got %v", votes)
+	}
+
+	// Only the latency policy votes to keep.
+	state = newDurationSubtrace(t, "s2", 200*time.Millisecond, ptrace.StatusCodeOk, nil)
+	keep, votes = s.Decide(state)
+	if !keep {
+		t.Errorf("expected overall decision to keep when one policy votes keep")
+	}
+	if votes["errors"] {
+		t.Errorf("expected status_code policy to vote drop for a non-error subtrace")
+	}
+	if !votes["slow"] {
+		t.Errorf("expected latency policy to vote keep for a slow subtrace")
+	}
+}
+
+func TestSampler_ProbabilisticIsDeterministicPerSubtrace(t *testing.T) {
+	s := mustSampler(t, []SamplingPolicy{{Name: "p", Type: "probabilistic", SamplingPercentage: 50}})
+	state := newDurationSubtrace(t, "stable-id", time.Millisecond, ptrace.StatusCodeUnset, nil)
+
+	keep1, _ := s.Decide(state)
+	keep2, _ := s.Decide(state)
+	if keep1 != keep2 {
+		t.Errorf("expected repeated decisions for the same subtrace ID to agree")
+	}
+}
@@ -0,0 +1,39 @@
+package subtraceaggregator
+
+import (
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// ReplayedSpan is one span recovered from Storage on Start, with enough
+// context to re-Add it to the Buffer exactly as bufferSpans would have.
+type ReplayedSpan struct {
+	TraceID      pcommon.TraceID
+	ResourceHash string
+	Span         ptrace.Span
+	Resource     ptrace.ResourceSpans
+	Scope        ptrace.ScopeSpans
+}
+
+// Storage durably records spans as they're buffered, so an in-flight trace
+// survives a collector restart, and lets the processor reclaim space once a
+// trace has been flushed or has expired.
+type Storage interface {
+	// Append durably records a span's arrival for traceID. bufferSpans calls
+	// this before adding the span to the in-memory Buffer (write-then-append),
+	// so a crash between buffering and the eventual flush cannot lose the
+	// span. If Append returns an error, bufferSpans logs it and still buffers
+	// the span, in memory only.
+	Append(traceID pcommon.TraceID, resourceHash string, span ptrace.Span, resource ptrace.ResourceSpans, scope ptrace.ScopeSpans) error
+
+	// Tombstone marks traceID as done (flushed or expired), so Replay skips
+	// it and compaction can reclaim the segments that only held its spans.
+	Tombstone(traceID pcommon.TraceID) error
+
+	// Replay returns every recorded span for every trace that hasn't been
+	// tombstoned, in append order, so Start can rebuild the Buffer.
+	Replay() ([]ReplayedSpan, error)
+
+	// Close stops compaction and releases the storage backend's resources.
+	Close() error
+}
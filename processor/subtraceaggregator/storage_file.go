@@ -0,0 +1,534 @@
+package subtraceaggregator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+const (
+	defaultMaxSegmentBytes = 64 * 1024 * 1024
+	segmentFilePrefix      = "segment-"
+	segmentFileSuffix      = ".log"
+	compactionInterval     = 10 * time.Second
+	fsyncInterval          = time.Second
+
+	recordTypeSpan      byte = 0
+	recordTypeTombstone byte = 1
+)
+
+// fileStorage is a Storage backend that appends records to a sequence of
+// segment files under Directory, replaying them on recovery and dropping
+// segments once every trace they reference has been tombstoned.
+type fileStorage struct {
+	dir             string
+	maxSegmentBytes int64
+	fsyncMode       string
+
+	mu              sync.Mutex
+	active          *os.File
+	activeID        int64
+	activeSize      int64
+	dirty           bool // true if active has unsynced writes, for fsync=interval
+	traceSegments   map[string]map[int64]struct{}
+	segmentRefs     map[int64]int
+	replayedOnStart []ReplayedSpan
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+var _ Storage = (*fileStorage)(nil)
+
+// NewFileStorage opens (or creates) the write-ahead log under cfg.Directory,
+// replaying every existing segment to recover in-flight traces and to seed
+// the bookkeeping compaction needs. The replayed spans are returned by the
+// first call to Replay.
+func NewFileStorage(cfg *StorageConfig) (*fileStorage, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("create storage directory: %w", err)
+	}
+
+	maxSegmentBytes := cfg.MaxSegmentBytes
+	if maxSegmentBytes <= 0 {
+		maxSegmentBytes = defaultMaxSegmentBytes
+	}
+	fsyncMode := cfg.Fsync
+	if fsyncMode == "" {
+		fsyncMode = "always"
+	}
+
+	s := &fileStorage{
+		dir:             cfg.Directory,
+		maxSegmentBytes: maxSegmentBytes,
+		fsyncMode:       fsyncMode,
+		traceSegments:   make(map[string]map[int64]struct{}),
+		segmentRefs:     make(map[int64]int),
+		closeCh:         make(chan struct{}),
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.runCompaction()
+	if fsyncMode == "interval" {
+		s.wg.Add(1)
+		go s.runFsyncInterval()
+	}
+
+	return s, nil
+}
+
+// recover replays every existing segment (oldest first) to rebuild
+// s.replayedOnStart and the per-segment liveness bookkeeping, truncates the
+// newest segment at the first torn/incomplete record (a crash mid-write),
+// and opens that segment (or a fresh one, if none exist) for appending.
+func (s *fileStorage) recover() error {
+	ids, err := s.existingSegmentIDs()
+	if err != nil {
+		return err
+	}
+
+	dead := make(map[string]bool)
+	var pending []ReplayedSpan
+
+	for i, id := range ids {
+		isLast := i == len(ids)-1
+		records, validOffset, err := s.scanSegment(id)
+		if err != nil {
+			return err
+		}
+		if isLast {
+			if err := s.truncateSegment(id, validOffset); err != nil {
+				return err
+			}
+		}
+
+		for _, rec := range records {
+			traceIDStr := rec.traceID.String()
+			if rec.recordType == recordTypeTombstone {
+				dead[traceIDStr] = true
+				continue
+			}
+			pending = append(pending, rec.span)
+			if s.traceSegments[traceIDStr] == nil {
+				s.traceSegments[traceIDStr] = make(map[int64]struct{})
+			}
+			if _, ok := s.traceSegments[traceIDStr][id]; !ok {
+				s.traceSegments[traceIDStr][id] = struct{}{}
+				s.segmentRefs[id]++
+			}
+		}
+	}
+
+	for _, span := range pending {
+		traceIDStr := span.TraceID.String()
+		if dead[traceIDStr] {
+			continue
+		}
+		s.replayedOnStart = append(s.replayedOnStart, span)
+	}
+	// Tombstoned traces contribute no live references; drop their bookkeeping
+	// so compaction can reclaim segments that only held dead traces.
+	for traceIDStr := range dead {
+		s.releaseTrace(traceIDStr)
+	}
+
+	if len(ids) == 0 {
+		return s.openNewSegment(0)
+	}
+	return s.openSegment(ids[len(ids)-1])
+}
+
+func (s *fileStorage) existingSegmentIDs() ([]int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("list storage directory: %w", err)
+	}
+
+	var ids []int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !strings.HasPrefix(name, segmentFilePrefix) || !strings.HasSuffix(name, segmentFileSuffix) {
+			continue
+		}
+		idStr := strings.TrimSuffix(strings.TrimPrefix(name, segmentFilePrefix), segmentFileSuffix)
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *fileStorage) segmentPath(id int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", segmentFilePrefix, id, segmentFileSuffix))
+}
+
+type decodedRecord struct {
+	recordType byte
+	traceID    pcommon.TraceID
+	span       ReplayedSpan
+}
+
+// scanSegment reads every well-formed record from segment id. validOffset is
+// the byte offset immediately after the last well-formed record; anything
+// past it (a length/body/checksum that doesn't fit or doesn't verify) is
+// treated as a torn write from a crash mid-append and ignored.
+func (s *fileStorage) scanSegment(id int64) (records []decodedRecord, validOffset int64, err error) {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return nil, 0, fmt.Errorf("open segment %d: %w", id, err)
+	}
+	defer f.Close()
+
+	var offset int64
+	for {
+		rec, recordLen, ok := readRecord(f)
+		if !ok {
+			break
+		}
+		offset += int64(4 + recordLen + 4)
+		records = append(records, rec)
+	}
+	return records, offset, nil
+}
+
+// readRecord reads one length-prefixed, checksummed record from r. ok is
+// false on EOF or on any malformed/short/corrupt record (a torn write).
+func readRecord(r io.Reader) (rec decodedRecord, recordLen int, ok bool) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return decodedRecord{}, 0, false
+	}
+	recordLen = int(binary.BigEndian.Uint32(lenBuf[:]))
+	if recordLen < 1+16 {
+		return decodedRecord{}, 0, false
+	}
+
+	body := make([]byte, recordLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return decodedRecord{}, 0, false
+	}
+
+	var crcBuf [4]byte
+	if _, err := io.ReadFull(r, crcBuf[:]); err != nil {
+		return decodedRecord{}, 0, false
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(crcBuf[:]) {
+		return decodedRecord{}, 0, false
+	}
+
+	recordType := body[0]
+	var traceID pcommon.TraceID
+	copy(traceID[:], body[1:17])
+	payload := body[17:]
+
+	rec = decodedRecord{recordType: recordType, traceID: traceID}
+	if recordType == recordTypeSpan {
+		span, err := decodeSpanPayload(traceID, payload)
+		if err != nil {
+			return decodedRecord{}, 0, false
+		}
+		rec.span = span
+	}
+	return rec, recordLen, true
+}
+
+func decodeSpanPayload(traceID pcommon.TraceID, payload []byte) (ReplayedSpan, error) {
+	if len(payload) < 2 {
+		return ReplayedSpan{}, fmt.Errorf("span payload too short")
+	}
+	hashLen := int(binary.BigEndian.Uint16(payload[0:2]))
+	payload = payload[2:]
+	if len(payload) < hashLen+4 {
+		return ReplayedSpan{}, fmt.Errorf("span payload too short for resource hash")
+	}
+	resourceHash := string(payload[:hashLen])
+	payload = payload[hashLen:]
+
+	protoLen := int(binary.BigEndian.Uint32(payload[0:4]))
+	payload = payload[4:]
+	if len(payload) < protoLen {
+		return ReplayedSpan{}, fmt.Errorf("span payload too short for proto bytes")
+	}
+
+	td, err := (&ptrace.ProtoUnmarshaler{}).UnmarshalTraces(payload[:protoLen])
+	if err != nil {
+		return ReplayedSpan{}, fmt.Errorf("unmarshal persisted span: %w", err)
+	}
+	if td.ResourceSpans().Len() != 1 || td.ResourceSpans().At(0).ScopeSpans().Len() != 1 || td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().Len() != 1 {
+		return ReplayedSpan{}, fmt.Errorf("unexpected persisted span shape")
+	}
+	rs := td.ResourceSpans().At(0)
+	ss := rs.ScopeSpans().At(0)
+	return ReplayedSpan{
+		TraceID:      traceID,
+		ResourceHash: resourceHash,
+		Span:         ss.Spans().At(0),
+		Resource:     rs,
+		Scope:        ss,
+	}, nil
+}
+
+func (s *fileStorage) truncateSegment(id int64, validOffset int64) error {
+	return os.Truncate(s.segmentPath(id), validOffset)
+}
+
+func (s *fileStorage) openSegment(id int64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open segment %d for append: %w", id, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat segment %d: %w", id, err)
+	}
+	s.active = f
+	s.activeID = id
+	s.activeSize = info.Size()
+	return nil
+}
+
+func (s *fileStorage) openNewSegment(id int64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create segment %d: %w", id, err)
+	}
+	s.active = f
+	s.activeID = id
+	s.activeSize = 0
+	return nil
+}
+
+// Append implements Storage.
+func (s *fileStorage) Append(traceID pcommon.TraceID, resourceHash string, span ptrace.Span, resource ptrace.ResourceSpans, scope ptrace.ScopeSpans) error {
+	payload := encodeSpanPayload(resourceHash, span, resource, scope)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeSize >= s.maxSegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := writeRecord(s.active, recordTypeSpan, traceID, payload)
+	if err != nil {
+		return fmt.Errorf("append span record: %w", err)
+	}
+	s.activeSize += int64(n)
+	s.dirty = true
+
+	traceIDStr := traceID.String()
+	if s.traceSegments[traceIDStr] == nil {
+		s.traceSegments[traceIDStr] = make(map[int64]struct{})
+	}
+	if _, ok := s.traceSegments[traceIDStr][s.activeID]; !ok {
+		s.traceSegments[traceIDStr][s.activeID] = struct{}{}
+		s.segmentRefs[s.activeID]++
+	}
+
+	if s.fsyncMode == "always" {
+		return s.active.Sync()
+	}
+	return nil
+}
+
+// Tombstone implements Storage.
+func (s *fileStorage) Tombstone(traceID pcommon.TraceID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeSize >= s.maxSegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := writeRecord(s.active, recordTypeTombstone, traceID, nil)
+	if err != nil {
+		return fmt.Errorf("append tombstone record: %w", err)
+	}
+	s.activeSize += int64(n)
+	s.dirty = true
+
+	s.releaseTrace(traceID.String())
+
+	if s.fsyncMode == "always" {
+		return s.active.Sync()
+	}
+	return nil
+}
+
+// releaseTrace drops the liveness bookkeeping for a trace that's now done
+// (tombstoned), decrementing the reference count of every segment that held
+// its spans so compaction can tell when a segment is fully reclaimable.
+func (s *fileStorage) releaseTrace(traceIDStr string) {
+	for id := range s.traceSegments[traceIDStr] {
+		s.segmentRefs[id]--
+	}
+	delete(s.traceSegments, traceIDStr)
+}
+
+func (s *fileStorage) rotateLocked() error {
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("close segment %d: %w", s.activeID, err)
+	}
+	return s.openNewSegment(s.activeID + 1)
+}
+
+// Replay implements Storage. It returns the spans recovered at construction
+// time and clears the cache, since it's only meaningful once, on Start.
+func (s *fileStorage) Replay() ([]ReplayedSpan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replayed := s.replayedOnStart
+	s.replayedOnStart = nil
+	return replayed, nil
+}
+
+// Close implements Storage.
+func (s *fileStorage) Close() error {
+	close(s.closeCh)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Close()
+}
+
+// runCompaction periodically deletes sealed segments whose referenced
+// traces have all been tombstoned or flushed.
+func (s *fileStorage) runCompaction() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.compactOnce()
+		}
+	}
+}
+
+func (s *fileStorage) compactOnce() {
+	s.mu.Lock()
+	var reclaimable []int64
+	for id, refs := range s.segmentRefs {
+		if refs <= 0 && id != s.activeID {
+			reclaimable = append(reclaimable, id)
+		}
+	}
+	for _, id := range reclaimable {
+		delete(s.segmentRefs, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range reclaimable {
+		_ = os.Remove(s.segmentPath(id))
+	}
+}
+
+// runFsyncInterval syncs the active segment on a fixed interval when
+// fsyncMode is "interval", instead of after every write.
+func (s *fileStorage) runFsyncInterval() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(fsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				_ = s.active.Sync()
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// encodeSpanPayload serializes a span record's payload: the resource hash,
+// followed by the span (with its resource and scope) proto-encoded as a
+// single-span ptrace.Traces.
+func encodeSpanPayload(resourceHash string, span ptrace.Span, resource ptrace.ResourceSpans, scope ptrace.ScopeSpans) []byte {
+	td := ptrace.NewTraces()
+	rs := appendResourceSpans(td, resource)
+	ss := appendScopeSpans(rs, scope)
+	span.CopyTo(ss.Spans().AppendEmpty())
+
+	protoBytes, err := (&ptrace.ProtoMarshaler{}).MarshalTraces(td)
+	if err != nil {
+		// Traces built entirely from already-valid pdata can't fail to
+		// marshal; treat it as unreachable rather than threading another
+		// error return through every Append caller.
+		panic(fmt.Sprintf("marshal persisted span: %v", err))
+	}
+
+	payload := make([]byte, 0, 2+len(resourceHash)+4+len(protoBytes))
+	hashLenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(hashLenBuf, uint16(len(resourceHash)))
+	payload = append(payload, hashLenBuf...)
+	payload = append(payload, resourceHash...)
+
+	protoLenBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(protoLenBuf, uint32(len(protoBytes)))
+	payload = append(payload, protoLenBuf...)
+	payload = append(payload, protoBytes...)
+	return payload
+}
+
+// writeRecord writes one length-prefixed, checksummed record and returns its
+// total on-disk size (4-byte length + body + 4-byte checksum).
+func writeRecord(w io.Writer, recordType byte, traceID pcommon.TraceID, payload []byte) (int, error) {
+	body := make([]byte, 0, 1+16+len(payload))
+	body = append(body, recordType)
+	body = append(body, traceID[:]...)
+	body = append(body, payload...)
+
+	lenBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(body)))
+
+	crcBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBuf, crc32.ChecksumIEEE(body))
+
+	if _, err := w.Write(lenBuf); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(crcBuf); err != nil {
+		return 0, err
+	}
+	return len(lenBuf) + len(body) + len(crcBuf), nil
+}
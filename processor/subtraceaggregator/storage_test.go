@@ -0,0 +1,178 @@
+package subtraceaggregator
+
+import (
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func testSpan(traceID byte) (ptrace.Span, ptrace.ResourceSpans, ptrace.ScopeSpans) {
+	span := ptrace.NewSpan()
+	span.SetTraceID(traceIDFor(traceID))
+	span.SetName("op")
+	return span, ptrace.NewResourceSpans(), ptrace.NewScopeSpans()
+}
+
+func newTestFileStorage(t *testing.T, dir string) *fileStorage {
+	t.Helper()
+	s, err := NewFileStorage(&StorageConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestFileStorage_AppendAndReplayAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(&StorageConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+
+	span, resource, scope := testSpan(1)
+	if err := s.Append(traceIDFor(1), "res1", span, resource, scope); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Replay only returns what recover() found on disk at construction
+	// time, simulating the processor rebuilding its Buffer on Start after
+	// a restart.
+	reopened := newTestFileStorage(t, dir)
+	replayed, err := reopened.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(replayed) != 1 {
+		t.Fatalf("expected 1 replayed span, got %d", len(replayed))
+	}
+	if replayed[0].TraceID != traceIDFor(1) || replayed[0].ResourceHash != "res1" {
+		t.Errorf("unexpected replayed span: %+v", replayed[0])
+	}
+
+	// Replay only returns what was recovered at construction time.
+	if replayed, err := reopened.Replay(); err != nil || len(replayed) != 0 {
+		t.Errorf("expected a second Replay to be empty, got %v, err %v", replayed, err)
+	}
+}
+
+func TestFileStorage_TombstoneExcludesFromReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(&StorageConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+
+	span1, resource1, scope1 := testSpan(1)
+	span2, resource2, scope2 := testSpan(2)
+	if err := s.Append(traceIDFor(1), "res1", span1, resource1, scope1); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Append(traceIDFor(2), "res1", span2, resource2, scope2); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Tombstone(traceIDFor(1)); err != nil {
+		t.Fatalf("Tombstone: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened := newTestFileStorage(t, dir)
+	replayed, err := reopened.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(replayed) != 1 {
+		t.Fatalf("expected 1 replayed span after tombstoning trace 1, got %d", len(replayed))
+	}
+	if replayed[0].TraceID != traceIDFor(2) {
+		t.Errorf("expected surviving trace 2, got %v", replayed[0].TraceID)
+	}
+}
+
+func TestFileStorage_RecoverTruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(&StorageConfig{Directory: dir})
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+
+	span, resource, scope := testSpan(1)
+	if err := s.Append(traceIDFor(1), "res1", span, resource, scope); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Simulate a crash mid-write by appending a few garbage bytes to the
+	// segment file after the last well-formed record.
+	segPath := s.segmentPath(0)
+	f, err := os.OpenFile(segPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open segment for corruption: %v", err)
+	}
+	if _, err := f.Write([]byte{0x00, 0x00, 0x00, 0xFF, 0x01}); err != nil {
+		t.Fatalf("write torn record: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened := newTestFileStorage(t, dir)
+	replayed, err := reopened.Replay()
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(replayed) != 1 {
+		t.Fatalf("expected the torn write to be dropped and the prior record kept, got %d spans", len(replayed))
+	}
+
+	// A subsequent append must land past the truncation point, not after
+	// the discarded garbage.
+	span2, resource2, scope2 := testSpan(2)
+	if err := reopened.Append(traceIDFor(2), "res1", span2, resource2, scope2); err != nil {
+		t.Fatalf("Append after recovery: %v", err)
+	}
+}
+
+func TestFileStorage_CompactionReclaimsSegmentsOfTombstonedTraces(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestFileStorage(t, dir)
+
+	// Force every span into its own segment so tombstoning trace 1 leaves a
+	// segment with no live references.
+	s.maxSegmentBytes = 1
+
+	span, resource, scope := testSpan(1)
+	if err := s.Append(traceIDFor(1), "res1", span, resource, scope); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	span2, resource2, scope2 := testSpan(2)
+	if err := s.Append(traceIDFor(2), "res1", span2, resource2, scope2); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Tombstone(traceIDFor(1)); err != nil {
+		t.Fatalf("Tombstone: %v", err)
+	}
+
+	s.compactOnce()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("expected at least the active segment to remain")
+	}
+	for id, refs := range s.segmentRefs {
+		if refs <= 0 {
+			t.Errorf("expected segment %d with no live refs to be reclaimed from bookkeeping", id)
+		}
+	}
+}
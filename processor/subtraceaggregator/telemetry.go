@@ -0,0 +1,81 @@
+package subtraceaggregator
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// meterName identifies this processor's self-metrics in the collector's
+// internal telemetry.
+const meterName = "github.com/davidHaunschmied/otel-subtrace-demo/processor/subtraceaggregator"
+
+// registerSelfMetrics publishes the Buffer's occupancy and eviction count as
+// the collector's internal telemetry, so operators can alert on buffer
+// pressure without needing to reconstruct it from logs.
+func (p *subtraceProcessor) registerSelfMetrics(telemetry component.TelemetrySettings) error {
+	meter := telemetry.MeterProvider.Meter(meterName)
+
+	tracesBuffered, err := meter.Int64ObservableGauge(
+		"otelcol_processor_subtraceaggregator_traces_buffered",
+		metric.WithDescription("Number of traces currently buffered, awaiting subtrace assembly."),
+	)
+	if err != nil {
+		return err
+	}
+
+	spansBuffered, err := meter.Int64ObservableGauge(
+		"otelcol_processor_subtraceaggregator_spans_buffered",
+		metric.WithDescription("Number of spans currently buffered, across all traces."),
+	)
+	if err != nil {
+		return err
+	}
+
+	evictionsTotal, err := meter.Int64ObservableCounter(
+		"otelcol_processor_subtraceaggregator_evictions_total",
+		metric.WithDescription("Number of traces evicted from the buffer due to MaxTraces/MaxTotalSpans before their timeout elapsed."),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := p.buffer.Stats()
+		o.ObserveInt64(tracesBuffered, int64(stats.TracesBuffered))
+		o.ObserveInt64(spansBuffered, int64(stats.SpansBuffered))
+		o.ObserveInt64(evictionsTotal, stats.EvictionsTotal)
+		return nil
+	}, tracesBuffered, spansBuffered, evictionsTotal)
+	if err != nil {
+		return err
+	}
+
+	p.samplingDecisions, err = meter.Int64Counter(
+		"otelcol_processor_subtraceaggregator_sampling_decisions_total",
+		metric.WithDescription("Number of subtrace sampling decisions, by policy and outcome."),
+	)
+	return err
+}
+
+// recordSamplingDecision increments the sampling-decisions counter for one
+// policy's vote on a subtrace. policy is "_overall" for the OR'd decision
+// across all policies.
+func (p *subtraceProcessor) recordSamplingDecision(policy string, kept bool) {
+	if p.samplingDecisions == nil {
+		return
+	}
+	decision := "drop"
+	if kept {
+		decision = "keep"
+	}
+	p.samplingDecisions.Add(context.Background(),
+		1,
+		metric.WithAttributes(
+			attribute.String("policy", policy),
+			attribute.String("decision", decision),
+		),
+	)
+}